@@ -0,0 +1,63 @@
+// Copyright (c) 2023-2024, Nubificus LTD
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+var checkpointCommand = cli.Command{
+	Name:  "checkpoint",
+	Usage: "checkpoint a running container",
+	ArgsUsage: `<container-id>
+
+Where "<container-id>" is the name for the instance of the container to be
+checkpointed.`,
+	Description: `The checkpoint command saves a snapshot of the unikernel (memory and
+device state) in the image directory. The container can later be restored
+from the image directory with urunc restore. Currently, only Firecracker
+supports checkpoints.`,
+	Flags: []cli.Flag{
+		cli.StringFlag{Name: "image-path", Value: "", Usage: "path for saving the snapshot files"},
+		cli.BoolFlag{Name: "leave-running", Usage: "leave the unikernel running after checkpointing"},
+	},
+	Action: func(context *cli.Context) error {
+		// FIXME: Remove or change level of log
+		logrus.WithField("args", os.Args).Info("urunc INVOKED")
+		if err := checkArgs(context, 1, exactArgs); err != nil {
+			return err
+		}
+		imagePath := context.String("image-path")
+		if imagePath == "" {
+			return errors.New("the image path must be specified with --image-path")
+		}
+		imagePath, err := filepath.Abs(imagePath)
+		if err != nil {
+			return err
+		}
+
+		// get Unikontainer data from state.json
+		unikontainer, err := getUnikontainer(context)
+		if err != nil {
+			return err
+		}
+		return unikontainer.Checkpoint(imagePath, context.Bool("leave-running"))
+	},
+}
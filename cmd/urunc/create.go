@@ -20,6 +20,7 @@ import (
 	"net"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"syscall"
 
 	"github.com/creack/pty"
@@ -71,21 +72,31 @@ var createCommand = cli.Command{
 		}
 
 		if !context.Bool("reexec") {
-			return createUnikontainer(context)
+			_, err := createUnikontainer(context, false)
+			return err
 		}
 
 		return reexecUnikontainer(context)
 	},
 }
 
+// reexecProcess holds the reexec process spawned by createUnikontainer,
+// which will eventually execve the VMM
+type reexecProcess struct {
+	cmd     *exec.Cmd
+	console *os.File // The pty master, if the terminal is attached to urunc
+}
+
 // createUnikontainer creates a Unikernel struct from bundle data, initializes it's base dir and state.json,
 // setups terminal if required and spawns reexec process,
 // waits for reexec process to notify, executes CreateRuntime hooks,
-// sends ACK to reexec process and executes CreateContainer hooks
-func createUnikontainer(context *cli.Context) error {
+// sends ACK to reexec process and executes CreateContainer hooks.
+// If attach is set and a terminal is requested, the pty master is kept by
+// urunc, instead of getting sent over the console socket.
+func createUnikontainer(context *cli.Context, attach bool) (*reexecProcess, error) {
 	containerID := context.Args().First()
 	if containerID == "" {
-		return fmt.Errorf("container id cannot be empty")
+		return nil, fmt.Errorf("container id cannot be empty")
 	}
 	metrics.Capture(containerID, "TS00")
 
@@ -99,7 +110,7 @@ func createUnikontainer(context *cli.Context) error {
 		var err error
 		bundlePath, err = os.Getwd()
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
 
@@ -109,15 +120,24 @@ func createUnikontainer(context *cli.Context) error {
 		if errors.Is(err, unikontainers.ErrQueueProxy) ||
 			errors.Is(err, unikontainers.ErrNotUnikernel) {
 			// Exec runc to handle non unikernel containers
-			return runcExec()
+			return nil, runcExec()
 		}
-		return err
+		return nil, err
 	}
 	metrics.Capture(containerID, "TS01")
 
+	// The image-path flag is only defined by the restore command
+	if imagePath := context.String("image-path"); imagePath != "" {
+		imagePath, err = filepath.Abs(imagePath)
+		if err != nil {
+			return nil, err
+		}
+		unikontainer.SetRestoreImage(imagePath)
+	}
+
 	err = unikontainer.InitialSetup()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	metrics.Capture(containerID, "TS02")
@@ -126,7 +146,7 @@ func createUnikontainer(context *cli.Context) error {
 	sockAddr := unikontainer.GetInitSockAddr()
 	listener, err := unikontainers.CreateListener(sockAddr, true)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer func() {
 		err = listener.Close()
@@ -135,29 +155,41 @@ func createUnikontainer(context *cli.Context) error {
 		}
 	}()
 	defer func() {
+		// The socket is already gone, if the container was cleaned up
 		err = syscall.Unlink(sockAddr)
-		if err != nil {
+		if err != nil && !errors.Is(err, syscall.ENOENT) {
 			logrus.WithError(err).Errorf("failed to unlink %s", sockAddr)
 		}
 	}()
 
-	// create reexec process
+	// create reexec process, or the monitor which in turn creates it
 	selfBinary, err := os.Executable()
 	if err != nil {
-		return fmt.Errorf("failed to retrieve urunc executable: %w", err)
+		return nil, fmt.Errorf("failed to retrieve urunc executable: %w", err)
+	}
+	monitored := context.GlobalBool("monitor")
+	cloneFlags, err := unikontainer.ReexecCloneFlags()
+	if err != nil {
+		return nil, err
 	}
-	myArgs := os.Args[1:]
-	myArgs = append(myArgs, "--reexec")
 	reexecCommand := &exec.Cmd{
 		Path: selfBinary,
-		Args: append([]string{selfBinary}, myArgs...),
+		Args: append([]string{selfBinary}, reexecArgs(context, bundlePath, containerID)...),
 		SysProcAttr: &syscall.SysProcAttr{
-			Cloneflags: syscall.CLONE_NEWNET,
+			Cloneflags: cloneFlags,
 		},
 		Env: os.Environ(),
 	}
+	if monitored {
+		reexecCommand = &exec.Cmd{
+			Path: selfBinary,
+			Args: append([]string{selfBinary}, monitorArgs(context, bundlePath, containerID)...),
+			Env:  os.Environ(),
+		}
+	}
 
 	// setup terminal if required and start reexec process
+	process := &reexecProcess{cmd: reexecCommand}
 	if unikontainer.Spec.Process.Terminal {
 		metrics.Capture(containerID, "TS03")
 
@@ -165,25 +197,15 @@ func createUnikontainer(context *cli.Context) error {
 		if err != nil {
 			logrus.WithError(err).Fatal("failed to create pty")
 		}
-		defer ptm.Close()
-		consoleSocket := context.String("console-socket")
-		conn, err := net.Dial("unix", consoleSocket)
-		if err != nil {
-			logrus.WithError(err).Fatal("failed to dial console socket")
-		}
-		defer conn.Close()
-
-		uc, ok := conn.(*net.UnixConn)
-		if !ok {
-			logrus.Fatal("failed to cast unix socket")
-		}
-		defer uc.Close()
-
-		// Send file descriptor over socket.
-		oob := unix.UnixRights(int(ptm.Fd()))
-		_, _, err = uc.WriteMsgUnix([]byte(ptm.Name()), oob, nil)
-		if err != nil {
-			logrus.WithError(err).Fatal("failed to send PTY file descriptor over socket")
+		if attach {
+			// The caller is responsible to forward the pty to its stdio
+			process.console = ptm
+		} else {
+			defer ptm.Close()
+			err = sendConsole(context.String("console-socket"), ptm)
+			if err != nil {
+				logrus.WithError(err).Fatal("failed to send PTY file descriptor over socket")
+			}
 		}
 	} else {
 		reexecCommand.Stdin = os.Stdin
@@ -197,31 +219,52 @@ func createUnikontainer(context *cli.Context) error {
 		}
 	}
 
-	// Wait for reexec process to notify us
-	err = unikontainers.AwaitMessage(listener, unikontainers.ReexecStarted)
+	// Wait for reexec process to notify us. If the monitor exits,
+	// the reexec process is gone too.
+	msg, err := unikontainers.AwaitMessage(listener, unikontainers.ReexecStarted, unikontainers.AwaitOptions{
+		Timeout: context.GlobalDuration("ipc-timeout"),
+		PeerPid: reexecCommand.Process.Pid,
+	})
 	if err != nil {
-		return err
+		cleanupReexec(unikontainer, reexecCommand)
+		return nil, fmt.Errorf("reexec process failed to start: %w", err)
 	}
 	metrics.Capture(containerID, "TS07")
 
-	// Retrieve reexec cmd's pid and write to file and state
+	// Retrieve reexec process's pid and write to file and state. When
+	// monitored, the reexec process is not our child and reports its pid.
 	pid := reexecCommand.Process.Pid
+	if monitored {
+		pid = msg.Pid
+	}
 	err = unikontainer.Create(pid)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if monitored {
+		err = unikontainer.SetMonitorPid(reexecCommand.Process.Pid)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if pidFile := context.String("pid-file"); pidFile != "" {
+		err = unikontainer.WritePidFile(pidFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to write pid file: %w", err)
+		}
 	}
 
 	// execute CreateRuntime hooks
 	err = unikontainer.ExecuteHooks("CreateRuntime")
 	if err != nil {
-		return fmt.Errorf("failed to execute CreateRuntime hooks: %w", err)
+		return nil, fmt.Errorf("failed to execute CreateRuntime hooks: %w", err)
 	}
 	metrics.Capture(containerID, "TS08")
 
 	// send ACK to reexec process
 	err = unikontainer.SendAckReexec()
 	if err != nil {
-		return fmt.Errorf("failed to send ACK to reexec process: %w", err)
+		return nil, fmt.Errorf("failed to send ACK to reexec process: %w", err)
 
 	}
 	metrics.Capture(containerID, "TS09")
@@ -229,17 +272,83 @@ func createUnikontainer(context *cli.Context) error {
 	// execute CreateRuntime hooks
 	err = unikontainer.ExecuteHooks("CreateContainer")
 	if err != nil {
-		return fmt.Errorf("failed to execute CreateRuntime hooks: %w", err)
+		return nil, fmt.Errorf("failed to execute CreateRuntime hooks: %w", err)
 	}
 	metrics.Capture(containerID, "TS11")
 
-	return nil
+	return process, nil
+}
+
+// cleanupReexec kills a reexec process (or its monitor) that failed to start
+// and removes the container directory, along with the sockets in it
+func cleanupReexec(unikontainer *unikontainers.Unikontainer, reexecCommand *exec.Cmd) {
+	err := reexecCommand.Process.Kill()
+	if err != nil && !errors.Is(err, os.ErrProcessDone) {
+		logrus.WithError(err).Error("failed to kill reexec process")
+	}
+	// Reap the process, its exit status is of no interest
+	_ = reexecCommand.Wait()
+	err = unikontainer.Delete()
+	if err != nil {
+		logrus.WithError(err).Errorf("failed to remove container %s", unikontainer.State.ID)
+	}
+}
+
+// sendConsole sends the pty master file descriptor over the console socket
+func sendConsole(consoleSocket string, ptm *os.File) error {
+	conn, err := net.Dial("unix", consoleSocket)
+	if err != nil {
+		return fmt.Errorf("failed to dial console socket: %w", err)
+	}
+	defer conn.Close()
+
+	uc, ok := conn.(*net.UnixConn)
+	if !ok {
+		return errors.New("failed to cast unix socket")
+	}
+	defer uc.Close()
+
+	// Send file descriptor over socket.
+	oob := unix.UnixRights(int(ptm.Fd()))
+	_, _, err = uc.WriteMsgUnix([]byte(ptm.Name()), oob, nil)
+	return err
+}
+
+// reexecArgs builds the arguments of the reexec process. The reexec process
+// is always spawned as "create --reexec", regardless of the command (create,
+// run or monitor) that spawned it.
+func reexecArgs(context *cli.Context, bundlePath string, containerID string) []string {
+	return append(globalArgs(context), "create", "--bundle", bundlePath, "--reexec", containerID)
+}
+
+// monitorArgs builds the arguments of the monitor process
+func monitorArgs(context *cli.Context, bundlePath string, containerID string) []string {
+	return append(globalArgs(context), "monitor", "--bundle", bundlePath, containerID)
+}
+
+// globalArgs builds the global options that are passed on to the urunc
+// processes spawned for the container
+func globalArgs(context *cli.Context) []string {
+	args := []string{"--root", context.GlobalString("root")}
+	for _, name := range []string{"log", "log-format"} {
+		if context.GlobalIsSet(name) {
+			args = append(args, "--"+name, context.GlobalString(name))
+		}
+	}
+	if context.GlobalBool("debug") {
+		args = append(args, "--debug")
+	}
+	if context.GlobalIsSet("ipc-timeout") {
+		args = append(args, "--ipc-timeout", context.GlobalDuration("ipc-timeout").String())
+	}
+	return args
 }
 
 // reexecUnikontainer gets a Unikernel struct from state.json,
 // sends ReexecStarted message to init.sock,
 // waits AckReexec message on urunc.sock,
-// waits StartExecve message on urunc.sock,
+// waits StartExecve message on urunc.sock and reports any
+// error until the execve to the sender of StartExecve,
 // executes Prestart hooks and finally execve's the unikernel vmm.
 func reexecUnikontainer(context *cli.Context) error {
 	// No need to check if containerID is valid, because it will get
@@ -250,6 +359,13 @@ func reexecUnikontainer(context *cli.Context) error {
 	// get Unikontainer data from state.json
 	unikontainer, err := getUnikontainer(context)
 	if err != nil {
+		// let the parent process know, instead of waiting for us
+		if containerID != "" {
+			serr := unikontainers.SendReexecFailed(context.GlobalString("root"), containerID, err)
+			if serr != nil {
+				logrus.WithError(serr).Error("failed to report error to parent process")
+			}
+		}
 		return err
 	}
 
@@ -262,23 +378,57 @@ func reexecUnikontainer(context *cli.Context) error {
 	}
 	metrics.Capture(containerID, "TS06")
 
-	// wait AckReexec message on urunc.sock from parent process
+	// wait AckReexec message on urunc.sock from parent process and then
+	// StartExecve message from urunc start process. Both messages are
+	// received on the same listener, otherwise a StartExecve message sent
+	// right after the AckReexec (e.g. from urunc run) might get lost.
 	socketPath := unikontainer.GetUruncSockAddr()
-	err = unikontainer.ListenAndAwaitMsg(socketPath, unikontainers.AckReexec)
+	listener, err := unikontainers.CreateListener(socketPath, true)
 	if err != nil {
 		return err
 	}
-	metrics.Capture(containerID, "TS10")
-
-	// wait StartExecve message on urunc.sock from urunc start process
-	err = unikontainer.ListenAndAwaitMsg(socketPath, unikontainers.StartExecve)
+	var conn *net.UnixConn
+	// The parent process must reply in time, but the start command
+	// might come at any point later
+	_, err = unikontainers.AwaitMessage(listener, unikontainers.AckReexec, unikontainers.AwaitOptions{
+		Timeout: context.GlobalDuration("ipc-timeout"),
+		PeerPid: os.Getppid(),
+	})
+	if err == nil {
+		metrics.Capture(containerID, "TS10")
+		conn, err = unikontainers.AwaitRequest(listener, unikontainers.StartExecve, unikontainers.AwaitOptions{})
+	}
+	// We can not defer the cleanup of the listener, since this process
+	// will execve the VMM
+	if cerr := listener.Close(); cerr != nil {
+		logrus.WithError(cerr).Error("failed to close listener")
+	}
+	if cerr := syscall.Unlink(socketPath); cerr != nil {
+		logrus.WithError(cerr).Errorf("failed to unlink %s", socketPath)
+	}
 	if err != nil {
 		return err
 	}
+	// The connection is closed on execve, which lets the start
+	// process know that the VMM was executed successfully
+	defer conn.Close()
 	metrics.Capture(containerID, "TS15")
 
-	unikontainer.State.Pid = os.Getpid()
-	err = unikontainer.Create(unikontainer.State.Pid)
+	err = execveUnikontainer(unikontainer)
+	if err != nil {
+		// report the error back to the start process
+		rerr := unikontainers.ReplyIPCError(conn, containerID, err)
+		if rerr != nil {
+			logrus.WithError(rerr).Error("failed to report error to start process")
+		}
+	}
+	return err
+}
+
+// execveUnikontainer executes the Prestart hooks and execve's the VMM
+func execveUnikontainer(unikontainer *unikontainers.Unikontainer) error {
+	unikontainer.State.Pid = unikontainers.SelfPid()
+	err := unikontainer.Create(unikontainer.State.Pid)
 	if err != nil {
 		return err
 	}
@@ -19,6 +19,7 @@ import (
 
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
+	"golang.org/x/sys/unix"
 )
 
 var deleteCommand = cli.Command{
@@ -52,7 +53,7 @@ status of "ubuntu01" as "stopped" the following will delete resources held for
 			return err
 		}
 		if context.Bool("force") {
-			err := unikontainer.Kill()
+			err := unikontainer.Kill(unix.SIGKILL, true)
 			if err != nil {
 				return err
 			}
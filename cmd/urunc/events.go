@@ -0,0 +1,97 @@
+// Copyright (c) 2023-2024, Nubificus LTD
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"time"
+
+	"github.com/nubificus/urunc/pkg/unikontainers"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+var eventsCommand = cli.Command{
+	Name:  "events",
+	Usage: "display container events such as OOM notifications, exit and resource stats",
+	ArgsUsage: `<container-id>
+
+Where "<container-id>" is the name for the instance of the container.`,
+	Description: `The events command displays information about the container. By default the
+information is displayed once every 5 seconds, until the VMM exits. The resource
+stats are read from the cgroup of the VMM, which must not hold any other process
+(e.g. the Firecracker jailer with URUNC_JAILER_PARENT_CGROUP).`,
+	Flags: []cli.Flag{
+		cli.DurationFlag{Name: "interval", Value: 5 * time.Second, Usage: "set the stats collection interval"},
+		cli.BoolFlag{Name: "stats", Usage: "display the container's stats then exit"},
+	},
+	Action: func(context *cli.Context) error {
+		// FIXME: Remove or change level of log
+		logrus.WithField("args", os.Args).Info("urunc INVOKED")
+		if err := checkArgs(context, 1, exactArgs); err != nil {
+			return err
+		}
+		interval := context.Duration("interval")
+		if interval <= 0 {
+			return errors.New("duration interval must be greater than 0")
+		}
+
+		// get Unikontainer data from state.json
+		unikontainer, err := getUnikontainer(context)
+		if err != nil {
+			return err
+		}
+		collector, err := unikontainer.NewStatsCollector()
+		if err != nil {
+			return err
+		}
+
+		id := unikontainer.State.ID
+		enc := json.NewEncoder(os.Stdout)
+		if context.Bool("stats") {
+			stats, err := collector.Stats()
+			if err != nil {
+				return err
+			}
+			return enc.Encode(unikontainers.Event{Type: "stats", ID: id, Data: stats})
+		}
+
+		oomCount := collector.OOMKillCount()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if count := collector.OOMKillCount(); count > oomCount {
+				oomCount = count
+				if err := enc.Encode(unikontainers.Event{Type: "oom", ID: id}); err != nil {
+					return err
+				}
+			}
+			if !collector.Running() {
+				return enc.Encode(unikontainers.Event{Type: "exit", ID: id})
+			}
+			stats, err := collector.Stats()
+			if err != nil {
+				logrus.WithError(err).Error("failed to collect stats")
+				continue
+			}
+			if err := enc.Encode(unikontainers.Event{Type: "stats", ID: id, Data: stats}); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+}
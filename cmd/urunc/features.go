@@ -0,0 +1,130 @@
+// Copyright (c) 2023-2024, Nubificus LTD
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+
+	"github.com/nubificus/urunc/pkg/unikontainers"
+	"github.com/nubificus/urunc/pkg/unikontainers/hypervisors"
+	"github.com/nubificus/urunc/pkg/unikontainers/unikernels"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+// The following types follow the OCI runtime features document.
+// The version of runtime-spec we depend on does not define them yet.
+
+type features struct {
+	OCIVersionMin string            `json:"ociVersionMin,omitempty"`
+	OCIVersionMax string            `json:"ociVersionMax,omitempty"`
+	Hooks         []string          `json:"hooks,omitempty"`
+	MountOptions  []string          `json:"mountOptions,omitempty"`
+	Linux         *linuxFeatures    `json:"linux,omitempty"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
+}
+
+type linuxFeatures struct {
+	Namespaces []string         `json:"namespaces,omitempty"`
+	Cgroup     *cgroupFeatures  `json:"cgroup,omitempty"`
+	Seccomp    *seccompFeatures `json:"seccomp,omitempty"`
+}
+
+type cgroupFeatures struct {
+	V1      *bool `json:"v1,omitempty"`
+	V2      *bool `json:"v2,omitempty"`
+	Systemd *bool `json:"systemd,omitempty"`
+}
+
+type seccompFeatures struct {
+	Enabled *bool `json:"enabled,omitempty"`
+}
+
+// urunc specific keys of the features annotations
+const (
+	featHypervisors = "com.urunc.hypervisors"
+	featUnikernels  = "com.urunc.unikernels"
+	featAnnotations = "com.urunc.annotations"
+	featVersion     = "com.urunc.version"
+)
+
+var featuresCommand = cli.Command{
+	Name:      "features",
+	Usage:     "show the enabled features",
+	ArgsUsage: "",
+	Description: `Show the enabled features.
+The result is parsable as a JSON.
+The urunc specific annotations list the hypervisors found on this host,
+the supported unikernel types and the recognized unikernel annotations.`,
+	Action: func(context *cli.Context) error {
+		// FIXME: Remove or change level of log
+		logrus.WithField("args", os.Args).Info("urunc INVOKED")
+		if err := checkArgs(context, 0, exactArgs); err != nil {
+			return err
+		}
+
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "    ")
+		return enc.Encode(getFeatures())
+	},
+}
+
+func getFeatures() *features {
+	// urunc applies its own seccomp filters to the VMM, when the spec
+	// contains a seccomp section. It does not manage cgroups.
+	enabled := true
+	disabled := false
+
+	var vmms []string
+	for _, vmm := range hypervisors.AvailableVMMs() {
+		vmms = append(vmms, string(vmm))
+	}
+	annotations := map[string]string{
+		featHypervisors: strings.Join(vmms, ","),
+		featUnikernels:  strings.Join(unikernels.SupportedUnikernels(), ","),
+		featAnnotations: strings.Join(unikontainers.SupportedAnnotations(), ","),
+	}
+	if version != "" {
+		annotations[featVersion] = version
+	}
+
+	return &features{
+		OCIVersionMin: "1.0.0",
+		OCIVersionMax: specs.Version,
+		Hooks: []string{
+			"prestart",
+			"createRuntime",
+			"createContainer",
+			"startContainer",
+			"poststart",
+			"poststop",
+		},
+		Linux: &linuxFeatures{
+			Namespaces: []string{string(specs.NetworkNamespace)},
+			Cgroup: &cgroupFeatures{
+				V1:      &disabled,
+				V2:      &disabled,
+				Systemd: &disabled,
+			},
+			Seccomp: &seccompFeatures{
+				Enabled: &enabled,
+			},
+		},
+		Annotations: annotations,
+	}
+}
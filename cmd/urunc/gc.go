@@ -0,0 +1,87 @@
+// Copyright (c) 2023-2024, Nubificus LTD
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/nubificus/urunc/pkg/unikontainers"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+var gcCommand = cli.Command{
+	Name:  "gc",
+	Usage: "release the resources of containers whose VMM and bundle are gone",
+	ArgsUsage: `
+
+Where the given root is specified via the global option "--root"
+(default: "/run/urunc").`,
+	Description: `The gc command scans the root directory for unikernel containers whose VMM
+is gone and releases every resource recorded for them: the extracted rootfs,
+the tap device with its TC filters, the iptables MASQUERADE rule and the
+container directory. A stopped container is only released once its bundle is
+gone, since until then it is owned by whoever created it (e.g. containerd).
+With "--dry-run", the state of the containers is left untouched.
+
+Containers that are still being created are left alone, unless their state has
+not changed for longer than the IPC timeout (see the global "--ipc-timeout").
+
+EXAMPLE:
+To list the resources that would be released, without releasing them:
+       # urunc gc --dry-run`,
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "dry-run",
+			Usage: "only list the leaked resources",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		// FIXME: Remove or change level of log
+		logrus.WithField("args", os.Args).Info("urunc INVOKED")
+		if err := checkArgs(context, 0, exactArgs); err != nil {
+			return err
+		}
+
+		// We have already made sure in main.go that root is not nil
+		rootDir := context.GlobalString("root")
+		leaks, err := unikontainers.FindLeaks(rootDir, context.GlobalDuration("ipc-timeout"))
+		if err != nil {
+			return err
+		}
+
+		dryRun := context.Bool("dry-run")
+		failed := 0
+		for _, leak := range leaks {
+			fmt.Println(leak.ID)
+			for _, resource := range leak.Resources {
+				fmt.Printf("\t%s\n", resource)
+			}
+			if dryRun {
+				continue
+			}
+			err := leak.Reclaim()
+			if err != nil {
+				logrus.WithError(err).Errorf("failed to release the resources of %s", leak.ID)
+				failed++
+			}
+		}
+		if failed > 0 {
+			return fmt.Errorf("failed to release the resources of %d out of %d containers", failed, len(leaks))
+		}
+		return nil
+	},
+}
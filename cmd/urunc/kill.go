@@ -15,10 +15,14 @@
 package main
 
 import (
+	"fmt"
 	"os"
+	"strconv"
+	"strings"
 
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
+	"golang.org/x/sys/unix"
 )
 
 var killCommand = cli.Command{
@@ -55,6 +59,37 @@ signal to the init process of the "ubuntu01" container:
 		if err != nil {
 			return err
 		}
-		return unikontainer.Kill()
+
+		sigstr := context.Args().Get(1)
+		if sigstr == "" {
+			sigstr = "SIGTERM"
+		}
+		signal, err := parseSignal(sigstr)
+		if err != nil {
+			return err
+		}
+		return unikontainer.Kill(signal, context.Bool("all"))
 	},
 }
+
+// parseSignal converts a signal given either as a number or as a name,
+// with or without the "SIG" prefix, to a unix.Signal
+func parseSignal(rawSignal string) (unix.Signal, error) {
+	s, err := strconv.Atoi(rawSignal)
+	if err == nil {
+		signal := unix.Signal(s)
+		if unix.SignalName(signal) == "" {
+			return -1, fmt.Errorf("unknown signal %q", rawSignal)
+		}
+		return signal, nil
+	}
+	sig := strings.ToUpper(rawSignal)
+	if !strings.HasPrefix(sig, "SIG") {
+		sig = "SIG" + sig
+	}
+	signal := unix.SignalNum(sig)
+	if signal == 0 {
+		return -1, fmt.Errorf("unknown signal %q", rawSignal)
+	}
+	return signal, nil
+}
@@ -0,0 +1,147 @@
+// Copyright (c) 2023-2024, Nubificus LTD
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/nubificus/urunc/pkg/unikontainers"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+// containerSummary holds the information printed by the list command
+// for each unikernel container
+type containerSummary struct {
+	ID            string `json:"id"`
+	Pid           int    `json:"pid"`
+	Status        string `json:"status"`
+	Bundle        string `json:"bundle"`
+	Hypervisor    string `json:"hypervisor"`
+	UnikernelType string `json:"unikernelType"`
+}
+
+var listCommand = cli.Command{
+	Name:  "list",
+	Usage: "lists containers started by urunc with the given root",
+	ArgsUsage: `
+
+Where the given root is specified via the global option "--root"
+(default: "/run/urunc").
+
+EXAMPLE 1:
+To list containers created via the default "--root":
+       # urunc list
+
+EXAMPLE 2:
+To list containers created using a non-default value for "--root":
+       # urunc --root value list`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "format, f",
+			Value: "table",
+			Usage: `select one of: table or json (default: "table")`,
+		},
+		cli.BoolFlag{
+			Name:  "quiet, q",
+			Usage: "display only container IDs",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if err := checkArgs(context, 0, exactArgs); err != nil {
+			return err
+		}
+		s, err := getContainers(context)
+		if err != nil {
+			return err
+		}
+
+		if context.Bool("quiet") {
+			for _, item := range s {
+				fmt.Println(item.ID)
+			}
+			return nil
+		}
+
+		switch context.String("format") {
+		case "table":
+			w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
+			fmt.Fprint(w, "ID\tPID\tSTATUS\tBUNDLE\tHYPERVISOR\tTYPE\n")
+			for _, item := range s {
+				fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\t%s\n",
+					item.ID,
+					item.Pid,
+					item.Status,
+					item.Bundle,
+					item.Hypervisor,
+					item.UnikernelType)
+			}
+			return w.Flush()
+		case "json":
+			return json.NewEncoder(os.Stdout).Encode(s)
+		default:
+			return errors.New("invalid format option")
+		}
+	},
+}
+
+// getContainers walks the root directory and returns a summary for every
+// unikernel container found there. Directories that do not belong to a
+// unikernel container (e.g. containers handled by runc) are skipped.
+func getContainers(context *cli.Context) ([]containerSummary, error) {
+	// We have already made sure in main.go that root is not nil
+	rootDir := context.GlobalString("root")
+	entries, err := os.ReadDir(rootDir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			// The root directory has not been created yet,
+			// so there are no containers.
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	s := []containerSummary{}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		unikontainer, err := unikontainers.Get(entry.Name(), rootDir)
+		if err != nil {
+			if !errors.Is(err, unikontainers.ErrNotUnikernel) {
+				logrus.WithError(err).Warnf("failed to load container %s", entry.Name())
+			}
+			continue
+		}
+		state := unikontainer.CurrentState()
+		if state.Status == specs.StateStopped {
+			state.Pid = 0
+		}
+		s = append(s, containerSummary{
+			ID:            state.ID,
+			Pid:           state.Pid,
+			Status:        string(state.Status),
+			Bundle:        state.Bundle,
+			Hypervisor:    unikontainer.Hypervisor(),
+			UnikernelType: unikontainer.UnikernelType(),
+		})
+	}
+	return s, nil
+}
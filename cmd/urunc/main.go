@@ -27,6 +27,7 @@ import (
 
 	"github.com/nubificus/urunc/internal/constants"
 	m "github.com/nubificus/urunc/internal/metrics"
+	"github.com/nubificus/urunc/pkg/unikontainers"
 	"github.com/sirupsen/logrus"
 	lSyslog "github.com/sirupsen/logrus/hooks/syslog"
 
@@ -90,6 +91,17 @@ func main() {
 			Name:  "systemd-cgroup",
 			Usage: "enable systemd cgroup support, expects cgroupsPath to be of form \"slice:prefix:name\" for e.g. \"system.slice:runc:434234\"",
 		},
+		cli.DurationFlag{
+			Name:   "ipc-timeout",
+			Value:  unikontainers.DefaultIPCTimeout,
+			EnvVar: "URUNC_IPC_TIMEOUT",
+			Usage:  "maximum time to wait for the urunc processes of a container to communicate (0 disables the timeout)",
+		},
+		cli.BoolFlag{
+			Name:   "monitor",
+			EnvVar: "URUNC_MONITOR",
+			Usage:  "wait for the VMM in a monitor process, which records its exit status and releases its resources",
+		},
 		cli.StringFlag{
 			Name:  "rootless",
 			Value: "auto",
@@ -97,13 +109,23 @@ func main() {
 		},
 	}
 	app.Commands = []cli.Command{
+		checkpointCommand,
 		createCommand,
 		deleteCommand,
+		eventsCommand,
+		featuresCommand,
+		gcCommand,
 		killCommand,
+		listCommand,
+		monitorCommand,
+		pauseCommand,
+		psCommand,
+		restoreCommand,
+		resumeCommand,
 		runCommand,
-		// specCommand,
+		specCommand,
 		startCommand,
-		// stateCommand,
+		stateCommand,
 	}
 	app.Before = func(context *cli.Context) error {
 		if err := reviseRootDir(context); err != nil {
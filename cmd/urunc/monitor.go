@@ -0,0 +1,151 @@
+// Copyright (c) 2023-2024, Nubificus LTD
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"runtime"
+	"syscall"
+
+	"github.com/nubificus/urunc/pkg/unikontainers"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+	"golang.org/x/sys/unix"
+)
+
+var monitorCommand = cli.Command{
+	Name:  "monitor",
+	Usage: "spawn the reexec process of a container and wait for its VMM",
+	ArgsUsage: `<container-id>
+
+Where "<container-id>" is the name for the instance of the container.`,
+	Description: `The monitor command is spawned by create, when the global option "--monitor"
+is set. It spawns the reexec process, which eventually execve's the VMM, and
+waits for it. Once the VMM exits, the monitor records the exit status of the
+application in the guest in the state of the container, releases its network
+resources and executes the Poststop hooks. The monitor exits with that status.`,
+	Hidden: true,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "bundle, b",
+			Value: "",
+			Usage: `path to the root of the bundle directory`,
+		},
+	},
+	Action: func(context *cli.Context) error {
+		// FIXME: Remove or change level of log
+		logrus.WithField("args", os.Args).Info("urunc INVOKED")
+		if err := checkArgs(context, 1, exactArgs); err != nil {
+			return err
+		}
+
+		status, err := monitorUnikontainer(context)
+		if err != nil {
+			return err
+		}
+		if status != 0 {
+			return cli.NewExitError("", status)
+		}
+		return nil
+	},
+}
+
+// monitorUnikontainer spawns the reexec process in a new network namespace,
+// waits for it and reaps the container. It returns the exit status of the application.
+func monitorUnikontainer(context *cli.Context) (int, error) {
+	containerID := context.Args().First()
+
+	// The reexec process gets killed when the thread that spawned it
+	// exits, so the monitor never leaves this thread
+	runtime.LockOSThread()
+
+	selfBinary, err := os.Executable()
+	if err != nil {
+		return -1, fmt.Errorf("failed to retrieve urunc executable: %w", err)
+	}
+	unikontainer, err := unikontainers.Get(containerID, context.GlobalString("root"))
+	if err != nil {
+		return -1, fmt.Errorf("failed to get container %s: %w", containerID, err)
+	}
+	cloneFlags, err := unikontainer.ReexecCloneFlags()
+	if err != nil {
+		return -1, err
+	}
+	reexecCommand := &exec.Cmd{
+		Path: selfBinary,
+		Args: append([]string{selfBinary}, reexecArgs(context, context.String("bundle"), containerID)...),
+		SysProcAttr: &syscall.SysProcAttr{
+			Cloneflags: cloneFlags,
+			Pdeathsig:  syscall.SIGKILL,
+		},
+		Env:    os.Environ(),
+		Stdin:  os.Stdin,
+		Stdout: os.Stdout,
+		Stderr: os.Stderr,
+	}
+
+	// Forward the signals sent to the monitor (e.g. by urunc run) to the VMM
+	signals := make(chan os.Signal, 16)
+	signal.Notify(signals, unix.SIGINT, unix.SIGTERM, unix.SIGHUP,
+		unix.SIGQUIT, unix.SIGUSR1, unix.SIGUSR2)
+	defer signal.Stop(signals)
+
+	err = reexecCommand.Start()
+	if err != nil {
+		return -1, fmt.Errorf("failed to start reexec process: %w", err)
+	}
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case sig := <-signals:
+				err := reexecCommand.Process.Signal(sig)
+				if err != nil {
+					logrus.WithError(err).Debugf("failed to forward signal %v", sig)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	status, reason := waitStatus(reexecCommand.Wait())
+	close(done)
+	logrus.WithFields(logrus.Fields{
+		"id":     containerID,
+		"status": status,
+		"reason": reason,
+	}).Info("VMM exited")
+
+	unikontainer, err = unikontainers.Get(containerID, context.GlobalString("root"))
+	if err != nil {
+		// The container was deleted in the meantime
+		if errors.Is(err, os.ErrNotExist) {
+			return status, nil
+		}
+		return status, fmt.Errorf("failed to get container %s: %w", containerID, err)
+	}
+	// Report the exit status of the application, rather than the VMM's
+	status, reason = unikontainer.ExitStatus(status, reason)
+	err = unikontainer.Reap(status, reason)
+	if err != nil {
+		logrus.WithError(err).Errorf("failed to reap container %s", containerID)
+	}
+	return status, nil
+}
@@ -0,0 +1,128 @@
+// Copyright (c) 2023-2024, Nubificus LTD
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+var psCommand = cli.Command{
+	Name:      "ps",
+	Usage:     "ps displays the VMM process and its threads running inside a container",
+	ArgsUsage: `<container-id>`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "format, f",
+			Value: "table",
+			Usage: `select one of: table or json (default: "table")`,
+		},
+	},
+	Action: func(context *cli.Context) error {
+		// FIXME: Remove or change level of log
+		logrus.WithField("args", os.Args).Info("urunc INVOKED")
+		if err := checkArgs(context, 1, exactArgs); err != nil {
+			return err
+		}
+
+		// get Unikontainer data from state.json
+		unikontainer, err := getUnikontainer(context)
+		if err != nil {
+			return err
+		}
+		state := unikontainer.CurrentState()
+		if state.Status != specs.StateRunning && state.Status != specs.StateCreated {
+			return fmt.Errorf("container %s is not running", state.ID)
+		}
+
+		switch context.String("format") {
+		case "table":
+			threads, err := getProcessThreads(state.Pid)
+			if err != nil {
+				return err
+			}
+			w := tabwriter.NewWriter(os.Stdout, 8, 1, 3, ' ', 0)
+			fmt.Fprint(w, "PID\tTID\tSTAT\tCMD\n")
+			for _, t := range threads {
+				fmt.Fprintf(w, "%d\t%d\t%s\t%s\n", state.Pid, t.tid, t.state, t.name)
+			}
+			return w.Flush()
+		case "json":
+			// Keep the same output as runc, which prints the list of PIDs
+			return json.NewEncoder(os.Stdout).Encode([]int{state.Pid})
+		default:
+			return errors.New("invalid format option")
+		}
+	},
+}
+
+// threadInfo holds the information of a thread read from /proc
+type threadInfo struct {
+	tid   int
+	state string
+	name  string
+}
+
+// getProcessThreads reads /proc/<pid>/task and returns the threads of the
+// given process
+func getProcessThreads(pid int) ([]threadInfo, error) {
+	taskDir := filepath.Join("/proc", strconv.Itoa(pid), "task")
+	entries, err := os.ReadDir(taskDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read threads of process %d: %w", pid, err)
+	}
+
+	threads := []threadInfo{}
+	for _, entry := range entries {
+		tid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(taskDir, entry.Name(), "stat"))
+		if err != nil {
+			// The thread might have exited in the meantime
+			continue
+		}
+		// The format of stat is "tid (comm) state ...", where comm
+		// might contain spaces or parentheses.
+		stat := string(data)
+		start := strings.IndexByte(stat, '(')
+		end := strings.LastIndexByte(stat, ')')
+		if start < 0 || end < start {
+			continue
+		}
+		fields := strings.Fields(stat[end+1:])
+		threadState := ""
+		if len(fields) > 0 {
+			threadState = fields[0]
+		}
+		threads = append(threads, threadInfo{
+			tid:   tid,
+			state: threadState,
+			name:  stat[start+1 : end],
+		})
+	}
+	return threads, nil
+}
@@ -15,11 +15,18 @@
 package main
 
 import (
-	"fmt"
+	"errors"
+	"io"
 	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
 
+	"github.com/containerd/console"
+	"github.com/creack/pty"
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
+	"golang.org/x/sys/unix"
 )
 
 var runUsage = `<container-id>
@@ -58,6 +65,10 @@ var runCommand = cli.Command{
 			Value: "",
 			Usage: "specify the file to write the process id to",
 		},
+		cli.BoolFlag{
+			Name:  "detach, d",
+			Usage: "detach from the container's process",
+		},
 	},
 	Action: func(context *cli.Context) error {
 		// FIXME: Remove or change level of log
@@ -67,14 +78,169 @@ var runCommand = cli.Command{
 			return err
 		}
 
-		// FIXME: This is a refactor of what the previous code did, however I have a feeling
-		// that it will not work...
-		if err := reexecUnikontainer(context); err != nil {
+		status, err := runUnikontainer(context)
+		if err != nil {
 			return err
 		}
-		if err := startUnikontainer(context); err != nil {
-			return err
+		if status != 0 {
+			// Propagate the exit status of the guest
+			return cli.NewExitError("", status)
 		}
-		return fmt.Errorf("urunc run failed: %w", nil)
+		return nil
 	},
 }
+
+// runUnikontainer creates and starts a unikernel container. Unless detach is set,
+// it forwards the stdio (or the terminal) of urunc to the VMM, waits for the VMM to
+// exit, executes the Poststop hooks, deletes the container and returns the exit status.
+func runUnikontainer(context *cli.Context) (int, error) {
+	detach := context.Bool("detach")
+	if !detach && context.String("console-socket") != "" {
+		return -1, errors.New("cannot use console socket if urunc will not detach")
+	}
+
+	process, err := createUnikontainer(context, !detach)
+	if err != nil {
+		return -1, err
+	}
+	if process.console != nil {
+		defer process.console.Close()
+	}
+
+	// Start forwarding signals before the VMM boots, so that we do not
+	// miss any of them
+	signals := make(chan os.Signal, 16)
+	if !detach {
+		signal.Notify(signals, unix.SIGINT, unix.SIGTERM, unix.SIGHUP,
+			unix.SIGQUIT, unix.SIGUSR1, unix.SIGUSR2, unix.SIGWINCH)
+		defer signal.Stop(signals)
+	}
+
+	err = startUnikontainer(context)
+	if err != nil {
+		destroyUnikontainer(context, process)
+		return -1, err
+	}
+	if detach {
+		return 0, nil
+	}
+
+	if process.console != nil {
+		restore, err := attachConsole(process.console)
+		if err != nil {
+			logrus.WithError(err).Error("failed to attach to the console")
+		} else {
+			defer restore()
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case sig := <-signals:
+				if sig == unix.SIGWINCH {
+					if process.console != nil {
+						_ = pty.InheritSize(os.Stdin, process.console)
+					}
+					continue
+				}
+				err := process.cmd.Process.Signal(sig)
+				if err != nil {
+					logrus.WithError(err).Debugf("failed to forward signal %v", sig)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	status, reason := waitStatus(process.cmd.Wait())
+	close(done)
+	// The monitor exits with the status of the application, which it has
+	// already recorded along with the actual reason
+	if !context.GlobalBool("monitor") {
+		unikontainer, err := getUnikontainer(context)
+		if err == nil {
+			status, reason = unikontainer.ExitStatus(status, reason)
+			err = unikontainer.SetExitStatus(status, reason)
+		}
+		if err != nil {
+			logrus.WithError(err).Error("failed to save the exit status")
+		}
+	}
+	destroyUnikontainer(context, process)
+	return status, nil
+}
+
+// waitStatus converts the error returned by exec.Cmd.Wait to an exit status,
+// following the shell convention of 128+signal for signaled processes,
+// and the reason the process exited
+func waitStatus(err error) (int, string) {
+	if err == nil {
+		return 0, "exited"
+	}
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		logrus.WithError(err).Error("failed to wait the VMM process")
+		return 255, "unknown"
+	}
+	ws, ok := exitErr.Sys().(syscall.WaitStatus)
+	if ok && ws.Signaled() {
+		return 128 + int(ws.Signal()), "killed by signal " + unix.SignalName(ws.Signal())
+	}
+	return exitErr.ExitCode(), "exited"
+}
+
+// destroyUnikontainer makes sure the VMM is not running and deletes any
+// resources held by the container, which also executes the Poststop hooks
+func destroyUnikontainer(context *cli.Context, process *reexecProcess) {
+	unikontainer, err := getUnikontainer(context)
+	if err != nil {
+		logrus.WithError(err).Error("failed to get container")
+		return
+	}
+	if process.cmd.ProcessState == nil {
+		// The process is still running, kill it and reap it. Kill the
+		// VMM first, so that the monitor, if any, gets to reap it.
+		err = unikontainer.Kill(unix.SIGKILL, false)
+		if err != nil {
+			_ = process.cmd.Process.Kill()
+		}
+		_ = process.cmd.Wait()
+	}
+	err = unikontainer.Delete()
+	if err != nil {
+		logrus.WithError(err).Error("failed to delete container")
+	}
+}
+
+// attachConsole puts the current terminal in raw mode and forwards it
+// to the pty of the container. It returns a function to restore the terminal.
+func attachConsole(ptm *os.File) (func(), error) {
+	current, err := console.ConsoleFromFile(os.Stdin)
+	if err != nil {
+		// stdin is not a terminal, just forward the data
+		go func() {
+			_, _ = io.Copy(ptm, os.Stdin)
+		}()
+		go func() {
+			_, _ = io.Copy(os.Stdout, ptm)
+		}()
+		return func() {}, nil
+	}
+	err = current.SetRaw()
+	if err != nil {
+		return nil, err
+	}
+	_ = pty.InheritSize(os.Stdin, ptm)
+	go func() {
+		_, _ = io.Copy(ptm, current)
+	}()
+	go func() {
+		_, _ = io.Copy(current, ptm)
+	}()
+	return func() {
+		_ = current.Reset()
+	}, nil
+}
@@ -0,0 +1,211 @@
+// Copyright (c) 2023-2024, Nubificus LTD
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/nubificus/urunc/pkg/unikontainers"
+	"github.com/nubificus/urunc/pkg/unikontainers/hypervisors"
+	"github.com/nubificus/urunc/pkg/unikontainers/unikernels"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+var specCommand = cli.Command{
+	Name:      "spec",
+	Usage:     "create a new specification file for a unikernel",
+	ArgsUsage: "",
+	Description: `The spec command creates the new specification file named "` + specConfig + `" for
+the bundle. The urunc specific annotations are base64 encoded, as expected by urunc.
+
+The unikernel binary (and optionally the initrd and block image) must be placed
+inside the root filesystem of the bundle, which defaults to "rootfs".
+
+An example of creating a bundle for a Unikraft unikernel running on QEMU:
+
+    # mkdir -p bundle/rootfs
+    # cp kernel bundle/rootfs/
+    # urunc spec --bundle bundle --unikernel-type unikraft --hypervisor qemu \
+      --binary /kernel --cmdline "kernel"
+    # urunc run --bundle bundle mycontainerid`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "bundle, b",
+			Value: "",
+			Usage: `path to the root of the bundle directory`,
+		},
+		cli.StringFlag{
+			Name:  "rootfs",
+			Value: "rootfs",
+			Usage: "path to the root filesystem, relative to the bundle directory",
+		},
+		cli.StringFlag{
+			Name:  "unikernel-type",
+			Usage: "the type of the unikernel (e.g. unikraft, rumprun)",
+		},
+		cli.StringFlag{
+			Name:  "unikernel-version",
+			Usage: "the version of the unikernel framework",
+		},
+		cli.StringFlag{
+			Name:  "hypervisor",
+			Usage: "the hypervisor to execute the unikernel (e.g. qemu, firecracker, hvt, spt)",
+		},
+		cli.StringFlag{
+			Name:  "binary",
+			Usage: "path of the unikernel binary inside the root filesystem",
+		},
+		cli.StringFlag{
+			Name:  "initrd",
+			Usage: "path of the initrd inside the root filesystem",
+		},
+		cli.StringFlag{
+			Name:  "block",
+			Usage: "path of the block image inside the root filesystem",
+		},
+		cli.StringFlag{
+			Name:  "block-mount-point",
+			Usage: "mount point of the block image inside the unikernel",
+		},
+		cli.StringFlag{
+			Name:  "cmdline",
+			Usage: "command line of the unikernel",
+		},
+		cli.BoolFlag{
+			Name:  "use-dm-block",
+			Usage: "use the devmapper snapshot of the container as the block device of the unikernel",
+		},
+		cli.BoolFlag{
+			Name:  "urunc-json",
+			Usage: "also write the urunc annotations to urunc.json inside the root filesystem",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		// FIXME: Remove or change level of log
+		logrus.WithField("args", os.Args).Info("urunc INVOKED")
+		if err := checkArgs(context, 0, exactArgs); err != nil {
+			return err
+		}
+
+		conf, err := unikernelConfigFromFlags(context)
+		if err != nil {
+			return err
+		}
+
+		bundle := context.String("bundle")
+		if bundle != "" {
+			if err := os.Chdir(bundle); err != nil {
+				return err
+			}
+		}
+		if _, err := os.Stat(specConfig); err == nil {
+			return fmt.Errorf("file %s exists. Remove it first", specConfig)
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+
+		rootfs := context.String("rootfs")
+		if context.Bool("urunc-json") {
+			if err := conf.WriteJSON(rootfs); err != nil {
+				return fmt.Errorf("failed to write urunc.json in %s: %w", rootfs, err)
+			}
+		}
+
+		data, err := json.MarshalIndent(unikernelSpec(conf, rootfs), "", "\t")
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(specConfig, data, 0o666) //nolint: gosec
+	},
+}
+
+// unikernelConfigFromFlags creates the Unikernel config from the flags of
+// the spec command and validates it
+func unikernelConfigFromFlags(context *cli.Context) (*unikontainers.UnikernelConfig, error) {
+	conf := &unikontainers.UnikernelConfig{
+		UnikernelType:    context.String("unikernel-type"),
+		UnikernelVersion: context.String("unikernel-version"),
+		UnikernelCmd:     context.String("cmdline"),
+		UnikernelBinary:  context.String("binary"),
+		Hypervisor:       context.String("hypervisor"),
+		Initrd:           context.String("initrd"),
+		Block:            context.String("block"),
+		BlkMntPoint:      context.String("block-mount-point"),
+		UseDMBlock:       strconv.FormatBool(context.Bool("use-dm-block")),
+	}
+
+	if conf.UnikernelBinary == "" {
+		return nil, errors.New("the unikernel binary must be specified with --binary")
+	}
+	if !filepath.IsAbs(conf.UnikernelBinary) {
+		return nil, fmt.Errorf("the unikernel binary %s must be an absolute path inside the root filesystem", conf.UnikernelBinary)
+	}
+	if _, err := unikernels.New(conf.UnikernelType); err != nil {
+		return nil, fmt.Errorf("invalid unikernel type %q, supported types: %v", conf.UnikernelType, unikernels.SupportedUnikernels())
+	}
+	supported := false
+	for _, vmm := range hypervisors.SupportedVMMs() {
+		if conf.Hypervisor == string(vmm) {
+			supported = true
+			break
+		}
+	}
+	if !supported {
+		return nil, fmt.Errorf("invalid hypervisor %q, supported hypervisors: %v", conf.Hypervisor, hypervisors.SupportedVMMs())
+	}
+	return conf, nil
+}
+
+// unikernelSpec returns a minimal spec to run the unikernel described by
+// the Unikernel config, with the config as base64 encoded annotations
+func unikernelSpec(conf *unikontainers.UnikernelConfig, rootfs string) *specs.Spec {
+	return &specs.Spec{
+		Version: specs.Version,
+		Root: &specs.Root{
+			Path:     rootfs,
+			Readonly: false,
+		},
+		Process: &specs.Process{
+			Terminal: false,
+			User:     specs.User{UID: 0, GID: 0},
+			Args:     []string{conf.UnikernelBinary},
+			Env: []string{
+				"PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin",
+			},
+			Cwd: "/",
+		},
+		Hostname:    "urunc",
+		Annotations: conf.Encode().Map(),
+		Linux: &specs.Linux{
+			Resources: &specs.LinuxResources{},
+			Namespaces: []specs.LinuxNamespace{
+				{Type: specs.NetworkNamespace},
+			},
+			// urunc enforces the seccomp filters of the VMM, unless the
+			// container is unconfined, which is a spec without a profile.
+			// The rules of the profile are not used.
+			Seccomp: &specs.LinuxSeccomp{
+				DefaultAction: specs.ActAllow,
+			},
+		},
+	}
+}
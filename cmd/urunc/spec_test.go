@@ -0,0 +1,35 @@
+// Copyright (c) 2023-2024, Nubificus LTD
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/nubificus/urunc/pkg/unikontainers"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnikernelSpec(t *testing.T) {
+	conf := &unikontainers.UnikernelConfig{
+		UnikernelType:   "unikraft",
+		UnikernelBinary: "/kernel",
+		Hypervisor:      "qemu",
+	}
+	spec := unikernelSpec(conf, "rootfs")
+	assert.Equal(t, "rootfs", spec.Root.Path)
+	assert.Equal(t, []string{"/kernel"}, spec.Process.Args)
+	// Without a seccomp profile, the VMM would run without its filters
+	assert.NotNil(t, spec.Linux.Seccomp, "Expected the spec to enable seccomp")
+}
@@ -15,10 +15,13 @@
 package main
 
 import (
+	"fmt"
 	"os"
 
+	"github.com/nubificus/urunc/pkg/unikontainers"
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
+	"golang.org/x/sys/unix"
 )
 
 var startCommand = cli.Command{
@@ -55,11 +58,31 @@ func startUnikontainer(context *cli.Context) error {
 	}
 	metrics.Capture(containerID, "TS13")
 
-	err = unikontainer.SendStartExecve()
+	err = unikontainer.Start(context.GlobalDuration("ipc-timeout"))
 	if err != nil {
 		return err
 	}
 	metrics.Capture(containerID, "TS14")
 
+	err = unikontainer.BootVMM()
+	if err != nil {
+		return abortStart(unikontainer, fmt.Errorf("failed to boot the guest: %w", err))
+	}
+
+	err = unikontainer.RestoreSnapshot()
+	if err != nil {
+		return abortStart(unikontainer, fmt.Errorf("failed to restore the guest: %w", err))
+	}
+
 	return unikontainer.ExecuteHooks("Poststart")
 }
+
+// abortStart kills a VMM that is running without a guest, since it failed
+// to boot or restore it, so that the container is stopped rather than running
+func abortStart(unikontainer *unikontainers.Unikontainer, err error) error {
+	kerr := unikontainer.Kill(unix.SIGKILL, false)
+	if kerr != nil {
+		logrus.WithError(kerr).Error("failed to kill the VMM")
+	}
+	return err
+}
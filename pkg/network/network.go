@@ -38,6 +38,7 @@ var netlog = logrus.WithField("subsystem", "network")
 type UnikernelNetworkInfo struct {
 	TapDevice string
 	EthDevice Interface
+	NATRule   *NATRule // The NAT rule added for the unikernel, if any
 }
 type Manager interface {
 	NetworkSetup() (*UnikernelNetworkInfo, error)
@@ -30,39 +30,68 @@ var StaticIPAddr = fmt.Sprintf("%s/24", constants.StaticNetworkTapIP)
 type StaticNetwork struct {
 }
 
+// NATRule is an iptables MASQUERADE rule added for the traffic of a unikernel
+type NATRule struct {
+	Interface string `json:"interface"`
+	Source    string `json:"source"`
+}
+
 // Apply the following rule:
 // iptables -t nat -A POSTROUTING -o <IF> -s <IP> -j MASQUERADE --wait 1
 // and write 1 to /proc/sys/net/ipv4/ip_forward to enable IP forwarding.
-func setNATRule(iface string, sourceIP string) error {
-	var args []string
-	var stdout, stderr bytes.Buffer
-
-	path, err := exec.LookPath("iptables")
-	if err != nil {
-		return err
-	}
-
+func setNATRule(iface string, sourceIP string) (*NATRule, error) {
 	file, err := os.OpenFile("/proc/sys/net/ipv4/ip_forward", os.O_WRONLY, 0644)
 	if err != nil {
-		return fmt.Errorf("failed to open /proc/sys/net/ipv4/ip_forward: %w", err)
+		return nil, fmt.Errorf("failed to open /proc/sys/net/ipv4/ip_forward: %w", err)
 	}
 	defer file.Close()
 
 	_, err = file.WriteString("1")
 	if err != nil {
-		return fmt.Errorf("failed to enable IP forwarding: %w", err)
+		return nil, fmt.Errorf("failed to enable IP forwarding: %w", err)
 	}
 	netlog.Debugf("Enabled IP forwarding")
 
+	rule := &NATRule{Interface: iface, Source: sourceIP}
+	err = runNATRule("-A", *rule)
+	if err != nil {
+		return nil, err
+	}
+	netlog.Infof("Applied iptables rule for NAT")
+
+	return rule, nil
+}
+
+// DeleteNATRule removes a rule added by the static network. The
+// rule is removed from the network namespace of the caller.
+func DeleteNATRule(rule NATRule) error {
+	err := runNATRule("-D", rule)
+	if err != nil {
+		return err
+	}
+	netlog.Infof("Deleted iptables rule for NAT")
+	return nil
+}
+
+// runNATRule runs iptables to append (-A) or delete (-D) the given rule
+func runNATRule(op string, rule NATRule) error {
+	var args []string
+	var stdout, stderr bytes.Buffer
+
+	path, err := exec.LookPath("iptables")
+	if err != nil {
+		return err
+	}
+
 	args = append(args, path)
 	args = append(args, "-t")
 	args = append(args, "nat")
-	args = append(args, "-A")
+	args = append(args, op)
 	args = append(args, "POSTROUTING")
 	args = append(args, "-s")
-	args = append(args, sourceIP)
+	args = append(args, rule.Source)
 	args = append(args, "-o")
-	args = append(args, iface)
+	args = append(args, rule.Interface)
 	args = append(args, "-j")
 	args = append(args, "MASQUERADE")
 	args = append(args, "--wait")
@@ -83,9 +112,6 @@ func setNATRule(iface string, sourceIP string) error {
 			return err
 		}
 	}
-
-	netlog.Infof("Applied iptables rule for NAT")
-
 	return nil
 }
 
@@ -101,12 +127,13 @@ func (n StaticNetwork) NetworkSetup() (*UnikernelNetworkInfo, error) {
 	if err != nil {
 		return nil, err
 	}
-	err = setNATRule(DefaultInterface, StaticIPAddr)
+	natRule, err := setNATRule(DefaultInterface, StaticIPAddr)
 	if err != nil {
 		return nil, err
 	}
 	return &UnikernelNetworkInfo{
 		TapDevice: newTapDevice.Attrs().Name,
+		NATRule:   natRule,
 		EthDevice: Interface{
 			IP:             constants.StaticNetworkUnikernelIP,
 			DefaultGateway: constants.StaticNetworkTapIP,
@@ -0,0 +1,263 @@
+// Copyright (c) 2023-2024, Nubificus LTD
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package unikontainers
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const cgroupRoot = "/sys/fs/cgroup"
+
+// processCgroup holds the cgroup directories of a process, per controller.
+// In the case of cgroup v2, all controllers share the same directory.
+type processCgroup struct {
+	unified bool
+	paths   map[string]string
+}
+
+// getProcessCgroup parses /proc/<pid>/cgroup and returns the cgroup
+// directories of the given process
+func getProcessCgroup(pid int) (*processCgroup, error) {
+	file, err := os.Open(filepath.Join("/proc", strconv.Itoa(pid), "cgroup"))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	_, err = os.Stat(filepath.Join(cgroupRoot, "cgroup.controllers"))
+	cg := &processCgroup{
+		unified: err == nil,
+		paths:   make(map[string]string),
+	}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// Every line has the format: hierarchy-ID:controller-list:cgroup-path
+		parts := strings.SplitN(scanner.Text(), ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		if cg.unified {
+			if parts[0] == "0" && parts[1] == "" {
+				cg.paths[""] = filepath.Join(cgroupRoot, parts[2])
+			}
+			continue
+		}
+		for _, controller := range strings.Split(parts[1], ",") {
+			if controller == "" {
+				continue
+			}
+			controller = strings.TrimPrefix(controller, "name=")
+			cg.paths[controller] = filepath.Join(cgroupRoot, controller, parts[2])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(cg.paths) == 0 {
+		return nil, fmt.Errorf("no cgroup found for process %d", pid)
+	}
+	return cg, nil
+}
+
+// path returns the directory of the given controller
+func (cg *processCgroup) path(controller string) string {
+	if cg.unified {
+		return cg.paths[""]
+	}
+	return cg.paths[controller]
+}
+
+// dedicated returns true if the cgroups of the controllers that the statistics
+// are read from hold no process besides the given one and its descendants
+func (cg *processCgroup) dedicated(pid int) (bool, error) {
+	own := map[int]bool{pid: true}
+	for _, descendant := range getDescendants(pid) {
+		own[descendant] = true
+	}
+	checked := make(map[string]bool)
+	for _, controller := range []string{"cpu", "cpuacct", "memory", "pids"} {
+		dir := cg.path(controller)
+		if dir == "" || checked[dir] {
+			continue
+		}
+		checked[dir] = true
+		data, err := os.ReadFile(filepath.Join(dir, "cgroup.procs"))
+		if err != nil {
+			return false, err
+		}
+		for _, field := range strings.Fields(string(data)) {
+			p, err := strconv.Atoi(field)
+			if err != nil {
+				return false, fmt.Errorf("invalid pid %q in %s: %w", field, dir, err)
+			}
+			if !own[p] {
+				return false, nil
+			}
+		}
+	}
+	return true, nil
+}
+
+// readUint reads a single unsigned integer from a cgroup file.
+// The value "max" is translated to math.MaxUint64.
+func readUint(path string) (uint64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	value := strings.TrimSpace(string(data))
+	if value == "max" {
+		return math.MaxUint64, nil
+	}
+	return strconv.ParseUint(value, 10, 64)
+}
+
+// readKeyValues reads a cgroup file with "key value" lines (e.g. memory.stat)
+func readKeyValues(path string) (map[string]uint64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	values := make(map[string]uint64)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		value, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		values[fields[0]] = value
+	}
+	return values, scanner.Err()
+}
+
+// cpuStats reads the CPU usage and throttling statistics of the cgroup
+func (cg *processCgroup) cpuStats() (CPU, error) {
+	var cpu CPU
+	if cg.unified {
+		stat, err := readKeyValues(filepath.Join(cg.path("cpu"), "cpu.stat"))
+		if err != nil {
+			return cpu, err
+		}
+		// cgroup v2 reports time in microseconds
+		cpu.Usage.Total = stat["usage_usec"] * 1000
+		cpu.Usage.User = stat["user_usec"] * 1000
+		cpu.Usage.Kernel = stat["system_usec"] * 1000
+		cpu.Throttling.Periods = stat["nr_periods"]
+		cpu.Throttling.ThrottledPeriods = stat["nr_throttled"]
+		cpu.Throttling.ThrottledTime = stat["throttled_usec"] * 1000
+		return cpu, nil
+	}
+
+	total, err := readUint(filepath.Join(cg.path("cpuacct"), "cpuacct.usage"))
+	if err != nil {
+		return cpu, err
+	}
+	cpu.Usage.Total = total
+	acct, err := readKeyValues(filepath.Join(cg.path("cpuacct"), "cpuacct.stat"))
+	if err == nil {
+		// cpuacct.stat reports time in USER_HZ, which is 100 on Linux
+		const nsPerTick = 10 * 1000 * 1000
+		cpu.Usage.User = acct["user"] * nsPerTick
+		cpu.Usage.Kernel = acct["system"] * nsPerTick
+	}
+	stat, err := readKeyValues(filepath.Join(cg.path("cpu"), "cpu.stat"))
+	if err == nil {
+		cpu.Throttling.Periods = stat["nr_periods"]
+		cpu.Throttling.ThrottledPeriods = stat["nr_throttled"]
+		cpu.Throttling.ThrottledTime = stat["throttled_time"]
+	}
+	return cpu, nil
+}
+
+// memoryStats reads the memory usage statistics of the cgroup
+func (cg *processCgroup) memoryStats() (Memory, error) {
+	var memory Memory
+	dir := cg.path("memory")
+	if cg.unified {
+		usage, err := readUint(filepath.Join(dir, "memory.current"))
+		if err != nil {
+			return memory, err
+		}
+		memory.Usage.Usage = usage
+		memory.Usage.Limit, _ = readUint(filepath.Join(dir, "memory.max"))
+		memory.Usage.Max, _ = readUint(filepath.Join(dir, "memory.peak"))
+		events, err := readKeyValues(filepath.Join(dir, "memory.events"))
+		if err == nil {
+			memory.Usage.Failcnt = events["max"]
+		}
+		stat, err := readKeyValues(filepath.Join(dir, "memory.stat"))
+		if err == nil {
+			memory.Cache = stat["file"]
+			memory.Raw = stat
+		}
+		return memory, nil
+	}
+
+	usage, err := readUint(filepath.Join(dir, "memory.usage_in_bytes"))
+	if err != nil {
+		return memory, err
+	}
+	memory.Usage.Usage = usage
+	memory.Usage.Limit, _ = readUint(filepath.Join(dir, "memory.limit_in_bytes"))
+	memory.Usage.Max, _ = readUint(filepath.Join(dir, "memory.max_usage_in_bytes"))
+	memory.Usage.Failcnt, _ = readUint(filepath.Join(dir, "memory.failcnt"))
+	stat, err := readKeyValues(filepath.Join(dir, "memory.stat"))
+	if err == nil {
+		memory.Cache = stat["cache"]
+		memory.Raw = stat
+	}
+	return memory, nil
+}
+
+// pidsStats reads the number of tasks in the cgroup and its limit
+func (cg *processCgroup) pidsStats() (Pids, error) {
+	var pids Pids
+	current, err := readUint(filepath.Join(cg.path("pids"), "pids.current"))
+	if err != nil {
+		return pids, err
+	}
+	pids.Current = current
+	limit, err := readUint(filepath.Join(cg.path("pids"), "pids.max"))
+	if err == nil && limit != math.MaxUint64 {
+		pids.Limit = limit
+	}
+	return pids, nil
+}
+
+// oomKillCount returns the number of processes in the cgroup that were
+// killed by the OOM killer
+func (cg *processCgroup) oomKillCount() uint64 {
+	file := "memory.oom_control"
+	if cg.unified {
+		file = "memory.events"
+	}
+	values, err := readKeyValues(filepath.Join(cg.path("memory"), file))
+	if err != nil {
+		return 0
+	}
+	return values["oom_kill"]
+}
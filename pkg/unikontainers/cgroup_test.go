@@ -0,0 +1,106 @@
+// Copyright (c) 2023-2024, Nubificus LTD
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package unikontainers
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadUint(t *testing.T) {
+	t.Run("read uint number", func(t *testing.T) {
+		t.Parallel()
+		path := filepath.Join(t.TempDir(), "memory.current")
+		err := os.WriteFile(path, []byte("4096\n"), 0600)
+		assert.NoError(t, err)
+
+		value, err := readUint(path)
+		assert.NoError(t, err, "Expected no error in reading value")
+		assert.Equal(t, uint64(4096), value, "Expected value to be 4096")
+	})
+
+	t.Run("read uint max", func(t *testing.T) {
+		t.Parallel()
+		path := filepath.Join(t.TempDir(), "memory.max")
+		err := os.WriteFile(path, []byte("max\n"), 0600)
+		assert.NoError(t, err)
+
+		value, err := readUint(path)
+		assert.NoError(t, err, "Expected no error in reading value")
+		assert.Equal(t, uint64(math.MaxUint64), value, "Expected max to be translated to MaxUint64")
+	})
+
+	t.Run("read uint invalid", func(t *testing.T) {
+		t.Parallel()
+		path := filepath.Join(t.TempDir(), "memory.current")
+		err := os.WriteFile(path, []byte("invalid"), 0600)
+		assert.NoError(t, err)
+
+		_, err = readUint(path)
+		assert.Error(t, err, "Expected an error for invalid value")
+	})
+}
+
+func TestReadKeyValues(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "memory.events")
+	err := os.WriteFile(path, []byte("low 0\nhigh 0\nmax 3\noom 1\noom_kill 1\ninvalid line here\n"), 0600)
+	assert.NoError(t, err)
+
+	values, err := readKeyValues(path)
+	assert.NoError(t, err, "Expected no error in reading values")
+	assert.Equal(t, uint64(3), values["max"], "Expected max to be 3")
+	assert.Equal(t, uint64(1), values["oom_kill"], "Expected oom_kill to be 1")
+	assert.Len(t, values, 5, "Expected invalid lines to be skipped")
+}
+
+func TestGetProcessCgroup(t *testing.T) {
+	cg, err := getProcessCgroup(os.Getpid())
+	if err != nil {
+		t.Skipf("cgroups are not available: %v", err)
+	}
+	assert.NotEmpty(t, cg.path("memory"), "Expected a memory cgroup directory")
+}
+
+func TestCgroupDedicated(t *testing.T) {
+	child, _ := startTestVMM(t, 0)
+	pid := os.Getpid()
+
+	tests := []struct {
+		name      string
+		procs     string
+		dedicated bool
+	}{
+		{name: "only the process", procs: strconv.Itoa(pid) + "\n", dedicated: true},
+		{name: "process and descendant", procs: strconv.Itoa(pid) + "\n" + strconv.Itoa(child.Process.Pid) + "\n", dedicated: true},
+		{name: "shared with other process", procs: "1\n" + strconv.Itoa(pid) + "\n", dedicated: false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			err := os.WriteFile(filepath.Join(dir, "cgroup.procs"), []byte(tc.procs), 0600)
+			assert.NoError(t, err)
+			cg := &processCgroup{unified: true, paths: map[string]string{"": dir}}
+
+			dedicated, err := cg.dedicated(pid)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.dedicated, dedicated)
+		})
+	}
+}
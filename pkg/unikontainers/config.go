@@ -47,6 +47,24 @@ const (
 	annotUseDMBlock    = "com.urunc.unikernel.useDMBlock"
 )
 
+// SupportedAnnotations returns the urunc specific annotations
+// that are recognized in the bundle's config.json or urunc.json
+func SupportedAnnotations() []string {
+	return []string{
+		annotType,
+		annotVersion,
+		annotBinary,
+		annotCmdLine,
+		annotHypervisor,
+		annotInitrd,
+		annotBlock,
+		annotBlockMntPoint,
+		annotUseDMBlock,
+		annotJailer,
+		annotFCAPI,
+	}
+}
+
 // A UnikernelConfig struct holds the info provided by bima image on how to execute our unikernel
 type UnikernelConfig struct {
 	UnikernelType    string `json:"com.urunc.unikernel.unikernelType"`
@@ -234,6 +252,35 @@ func (c *UnikernelConfig) decode() error {
 	return nil
 }
 
+// Encode returns a copy of the Unikernel config with base64 encoded values,
+// as expected in the bundle annotations and the urunc.json file
+func (c *UnikernelConfig) Encode() *UnikernelConfig {
+	encode := func(value string) string {
+		return base64.StdEncoding.EncodeToString([]byte(value))
+	}
+	return &UnikernelConfig{
+		UnikernelType:    encode(c.UnikernelType),
+		UnikernelVersion: encode(c.UnikernelVersion),
+		UnikernelCmd:     encode(c.UnikernelCmd),
+		UnikernelBinary:  encode(c.UnikernelBinary),
+		Hypervisor:       encode(c.Hypervisor),
+		Initrd:           encode(c.Initrd),
+		Block:            encode(c.Block),
+		BlkMntPoint:      encode(c.BlkMntPoint),
+		UseDMBlock:       encode(c.UseDMBlock),
+	}
+}
+
+// WriteJSON writes the base64 encoded Unikernel config to the urunc.json
+// file inside the given rootfs directory
+func (c *UnikernelConfig) WriteJSON(rootFSDir string) error {
+	data, err := json.MarshalIndent(c.Encode(), "", "\t")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(rootFSDir, uruncJSONFilename), data, 0o644) //nolint: gosec
+}
+
 // Map returns a map containing the Unikernel config data
 func (c *UnikernelConfig) Map() map[string]string {
 	myMap := make(map[string]string)
@@ -301,3 +301,27 @@ func TestMap(t *testing.T) {
 		assert.Equal(t, expectedMap, resultMap)
 	})
 }
+
+func TestEncode(t *testing.T) {
+	t.Run("unikernelConfig encode decode roundtrip", func(t *testing.T) {
+		t.Parallel()
+		config := &UnikernelConfig{
+			UnikernelBinary:  "binary_value",
+			UnikernelType:    "type_value",
+			UnikernelVersion: "version_value",
+			UnikernelCmd:     "cmd_value --with args",
+			Hypervisor:       "hypervisor_value",
+			Initrd:           "",
+			Block:            "block_value",
+			BlkMntPoint:      "point_value",
+			UseDMBlock:       "false",
+		}
+		encoded := config.Encode()
+		assert.Equal(t, base64.StdEncoding.EncodeToString([]byte("binary_value")), encoded.UnikernelBinary)
+		assert.Equal(t, "", encoded.Initrd)
+
+		err := encoded.decode()
+		assert.NoError(t, err)
+		assert.Equal(t, config, encoded)
+	})
+}
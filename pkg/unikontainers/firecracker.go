@@ -0,0 +1,41 @@
+// Copyright (c) 2023-2024, Nubificus LTD
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package unikontainers
+
+import (
+	"github.com/nubificus/urunc/pkg/unikontainers/hypervisors"
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// The API mode of Firecracker is enabled for all containers of the host
+// through the environment of urunc, or per container through the annotation,
+// which takes precedence. In API mode, urunc configures and boots the microVM
+// through the API socket, instead of the command line of Firecracker.
+const (
+	annotFCAPI = "com.urunc.firecracker.api"
+	fcAPIEnv   = "URUNC_FIRECRACKER_API"
+)
+
+// firecrackerOptions sets the options of the VMM arguments that only apply
+// to Firecracker, i.e. the API and the jailer mode
+func firecrackerOptions(spec *specs.Spec, args *hypervisors.ExecArgs) error {
+	var err error
+	args.Jailer, err = jailerConfig(spec)
+	if err != nil {
+		return err
+	}
+	args.APIBoot, err = boolOption(spec, annotFCAPI, fcAPIEnv)
+	return err
+}
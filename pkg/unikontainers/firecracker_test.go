@@ -0,0 +1,42 @@
+// Copyright (c) 2023-2024, Nubificus LTD
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package unikontainers
+
+import (
+	"testing"
+
+	"github.com/nubificus/urunc/pkg/unikontainers/hypervisors"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFirecrackerOptions(t *testing.T) {
+	t.Setenv(jailerEnv, "")
+	t.Setenv(fcAPIEnv, "true")
+
+	var args hypervisors.ExecArgs
+	err := firecrackerOptions(&specs.Spec{}, &args)
+	assert.NoError(t, err)
+	assert.True(t, args.APIBoot, "Expected the API mode of the host")
+	assert.Nil(t, args.Jailer)
+
+	args = hypervisors.ExecArgs{}
+	err = firecrackerOptions(&specs.Spec{Annotations: map[string]string{annotFCAPI: "false"}}, &args)
+	assert.NoError(t, err)
+	assert.False(t, args.APIBoot, "Expected the annotation to disable the API mode")
+
+	err = firecrackerOptions(&specs.Spec{Annotations: map[string]string{annotFCAPI: "maybe"}}, &args)
+	assert.Error(t, err)
+}
@@ -0,0 +1,183 @@
+// Copyright (c) 2023-2024, Nubificus LTD
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package unikontainers
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// Leak is a container whose VMM and bundle are gone, but whose resources on the
+// host were never released (e.g. because the node or containerd crashed)
+type Leak struct {
+	ID        string
+	Resources []string // A description of each resource that will be released
+
+	dir          string
+	unikontainer *Unikontainer // nil if the directory has no state
+}
+
+// FindLeaks scans the root directory for leaked containers. Containers that
+// are still being created are only considered leaked if their state has not
+// changed for longer than grace, so that an ongoing create is not disturbed.
+// Directories that do not belong to urunc (e.g. containers handled by runc)
+// are skipped.
+func FindLeaks(rootDir string, grace time.Duration) ([]Leak, error) {
+	entries, err := os.ReadDir(rootDir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var leaks []Leak
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		leak, err := findLeak(rootDir, entry.Name(), grace)
+		if err != nil {
+			Log.WithError(err).Warnf("failed to inspect container %s", entry.Name())
+			continue
+		}
+		if leak != nil {
+			leaks = append(leaks, *leak)
+		}
+	}
+	return leaks, nil
+}
+
+// findLeak returns the leak of the given container, or nil if the container
+// is still in use or does not belong to urunc
+func findLeak(rootDir string, containerID string, grace time.Duration) (*Leak, error) {
+	containerDir := filepath.Join(rootDir, containerID)
+	stateFilePath := filepath.Join(containerDir, stateFilename)
+	info, err := os.Stat(stateFilePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return findStatelessLeak(containerDir, containerID, grace)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	cs, err := loadUnikontainerState(stateFilePath)
+	if err != nil {
+		return nil, err
+	}
+	if cs.OCI.Annotations[annotType] == "" {
+		return nil, nil
+	}
+	// The bundle of a leaked container is often gone, but it is
+	// not needed to release the resources
+	spec, err := loadSpec(cs.OCI.Bundle)
+	if err != nil {
+		spec = &specs.Spec{}
+	}
+	u := &Unikontainer{
+		BaseDir: containerDir,
+		RootDir: rootDir,
+		Spec:    spec,
+		State:   cs.OCI,
+		runtime: cs.Runtime,
+	}
+
+	// The state is only probed, so that a dry run does not change it
+	switch {
+	case u.State.Status == specs.StateStopped || u.exited():
+		// The container is still owned by containerd (or whoever created
+		// it) as long as its bundle exists, even if the VMM is gone
+		_, err := os.Stat(u.State.Bundle)
+		if err == nil {
+			return nil, nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	case u.State.Status == specs.StateCreating:
+		if time.Since(info.ModTime()) < grace {
+			return nil, nil
+		}
+	default:
+		return nil, nil
+	}
+	return &Leak{
+		ID:           containerID,
+		Resources:    u.recordedResources(),
+		dir:          containerDir,
+		unikontainer: u,
+	}, nil
+}
+
+// findStatelessLeak handles a container directory without a state file,
+// which is left behind if urunc dies right after creating it. To avoid
+// touching directories of other runtimes, the directory is only considered
+// leaked if it contains nothing besides the files that urunc creates there.
+func findStatelessLeak(containerDir string, containerID string, grace time.Duration) (*Leak, error) {
+	info, err := os.Stat(containerDir)
+	if err != nil {
+		return nil, err
+	}
+	if time.Since(info.ModTime()) < grace {
+		return nil, nil
+	}
+	entries, err := os.ReadDir(containerDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		switch entry.Name() {
+		case initSock, uruncSock, lockFilename:
+		default:
+			return nil, nil
+		}
+	}
+	return &Leak{
+		ID:        containerID,
+		Resources: []string{fmt.Sprintf("container directory %s", containerDir)},
+		dir:       containerDir,
+	}, nil
+}
+
+// recordedResources describes the resources recorded in the state of the
+// container, in the order that Delete releases them
+func (u *Unikontainer) recordedResources() []string {
+	var resources []string
+	if len(u.runtime.ExtractedFiles) > 0 {
+		resources = append(resources, fmt.Sprintf("extracted rootfs %s", filepath.Join(u.State.Bundle, rootfsDirName)))
+	}
+	if netnsPath := u.runtime.NetnsPath; netnsPath != "" {
+		if u.runtime.TapDevice != "" {
+			resources = append(resources, fmt.Sprintf("tap device %s and its TC filters in netns %s", u.runtime.TapDevice, netnsPath))
+		}
+		if rule := u.runtime.NATRule; rule != nil {
+			resources = append(resources, fmt.Sprintf("iptables MASQUERADE rule for %s on %s in netns %s", rule.Source, rule.Interface, netnsPath))
+		}
+	}
+	return append(resources, fmt.Sprintf("container directory %s", u.BaseDir))
+}
+
+// Reclaim releases the resources of the leaked container
+func (l Leak) Reclaim() error {
+	if l.unikontainer == nil {
+		return os.RemoveAll(l.dir)
+	}
+	return l.unikontainer.Delete()
+}
@@ -0,0 +1,143 @@
+// Copyright (c) 2023-2024, Nubificus LTD
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package unikontainers
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nubificus/urunc/pkg/network"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func saveGCTestContainer(t *testing.T, rootDir string, id string, status specs.ContainerState, pid int, annotations map[string]string) *Unikontainer {
+	t.Helper()
+	u := &Unikontainer{
+		BaseDir: filepath.Join(rootDir, id),
+		RootDir: rootDir,
+		Spec:    &specs.Spec{},
+		State: &specs.State{
+			ID:          id,
+			Status:      status,
+			Pid:         pid,
+			Bundle:      filepath.Join(rootDir, "missing-bundle"),
+			Annotations: annotations,
+		},
+	}
+	assert.NoError(t, os.MkdirAll(u.BaseDir, 0o755))
+	assert.NoError(t, u.saveContainerState())
+	return u
+}
+
+func leakIDs(leaks []Leak) []string {
+	ids := []string{}
+	for _, leak := range leaks {
+		ids = append(ids, leak.ID)
+	}
+	return ids
+}
+
+func TestFindLeaks(t *testing.T) {
+	rootDir := t.TempDir()
+	unikernel := map[string]string{annotType: "unikraft", annotHypervisor: "qemu"}
+
+	stopped := saveGCTestContainer(t, rootDir, "stopped", specs.StateStopped, 0, unikernel)
+	stopped.runtime.TapDevice = "tap0_urunc"
+	stopped.runtime.NetnsPath = "/proc/1/ns/net"
+	stopped.runtime.NATRule = &network.NATRule{Interface: "eth0", Source: "172.16.1.1/24"}
+	assert.NoError(t, stopped.saveContainerState())
+
+	running := saveGCTestContainer(t, rootDir, "running", specs.StateRunning, os.Getpid(), unikernel)
+	startTime, err := getProcessStartTime(os.Getpid())
+	assert.NoError(t, err)
+	running.runtime.StartTime = startTime
+	assert.NoError(t, running.saveContainerState())
+
+	saveGCTestContainer(t, rootDir, "creating", specs.StateCreating, 0, unikernel)
+	saveGCTestContainer(t, rootDir, "runc", specs.StateStopped, 0, map[string]string{})
+
+	// A stopped container whose bundle still exists, which has not been
+	// deleted by its owner yet
+	owned := saveGCTestContainer(t, rootDir, "owned", specs.StateStopped, 0, unikernel)
+	owned.State.Bundle = t.TempDir()
+	assert.NoError(t, owned.saveContainerState())
+
+	// A container whose VMM is gone, but which is not marked as stopped
+	exited := saveGCTestContainer(t, rootDir, "exited", specs.StateRunning, os.Getpid(), unikernel)
+	exited.runtime.StartTime = "0"
+	assert.NoError(t, exited.saveContainerState())
+
+	// A directory left behind right after its creation
+	assert.NoError(t, os.MkdirAll(filepath.Join(rootDir, "stateless"), 0o755))
+	assert.NoError(t, os.WriteFile(filepath.Join(rootDir, "stateless", initSock), nil, 0o644))
+	// A directory of another runtime
+	assert.NoError(t, os.MkdirAll(filepath.Join(rootDir, "other"), 0o755))
+	assert.NoError(t, os.WriteFile(filepath.Join(rootDir, "other", "data"), nil, 0o644))
+
+	t.Run("find leaks within grace", func(t *testing.T) {
+		leaks, err := FindLeaks(rootDir, time.Hour)
+		assert.NoError(t, err)
+		assert.ElementsMatch(t, []string{"stopped", "exited"}, leakIDs(leaks))
+		for _, leak := range leaks {
+			if leak.ID != "stopped" {
+				continue
+			}
+			assert.Equal(t, []string{
+				"tap device tap0_urunc and its TC filters in netns /proc/1/ns/net",
+				"iptables MASQUERADE rule for 172.16.1.1/24 on eth0 in netns /proc/1/ns/net",
+				"container directory " + stopped.BaseDir,
+			}, leak.Resources)
+		}
+
+		// Finding the leaks must not change their state
+		saved, err := loadUnikontainerState(filepath.Join(exited.BaseDir, stateFilename))
+		assert.NoError(t, err)
+		assert.Equal(t, specs.StateRunning, saved.OCI.Status)
+		assert.Empty(t, saved.Runtime.ExitReason)
+	})
+
+	t.Run("find leaks after grace", func(t *testing.T) {
+		leaks, err := FindLeaks(rootDir, 0)
+		assert.NoError(t, err)
+		assert.ElementsMatch(t, []string{"stopped", "exited", "creating", "stateless"}, leakIDs(leaks))
+	})
+
+	t.Run("find leaks missing root", func(t *testing.T) {
+		leaks, err := FindLeaks(filepath.Join(rootDir, "missing"), 0)
+		assert.NoError(t, err)
+		assert.Empty(t, leaks)
+	})
+}
+
+func TestLeakReclaim(t *testing.T) {
+	rootDir := t.TempDir()
+	unikernel := map[string]string{annotType: "unikraft", annotHypervisor: "qemu"}
+	saveGCTestContainer(t, rootDir, "creating", specs.StateCreating, 0, unikernel)
+	assert.NoError(t, os.MkdirAll(filepath.Join(rootDir, "stateless"), 0o755))
+
+	leaks, err := FindLeaks(rootDir, 0)
+	assert.NoError(t, err)
+	assert.Len(t, leaks, 2)
+	for _, leak := range leaks {
+		assert.NoError(t, leak.Reclaim())
+	}
+
+	entries, err := os.ReadDir(rootDir)
+	assert.NoError(t, err)
+	assert.Empty(t, entries, "Expected all leaked containers to be removed")
+}
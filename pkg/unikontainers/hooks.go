@@ -0,0 +1,299 @@
+// Copyright (c) 2023-2024, Nubificus LTD
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package unikontainers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/sirupsen/logrus"
+	"github.com/vishvananda/netns"
+	"golang.org/x/sys/unix"
+)
+
+// The environment variable that selects how the hooks of each stage are
+// executed. The OCI spec requires sequential execution, which is the default.
+const (
+	hooksModeEnv    = "URUNC_HOOKS_MODE"
+	hooksSequential = "sequential"
+	hooksConcurrent = "concurrent"
+)
+
+// ErrHookTimeout is returned when a hook does not complete within its timeout
+var ErrHookTimeout = errors.New("hook timed out")
+
+// hooksMode returns the configured hook execution mode
+func hooksMode() string {
+	mode := os.Getenv(hooksModeEnv)
+	switch mode {
+	case "", hooksSequential:
+		return hooksSequential
+	case hooksConcurrent:
+		return hooksConcurrent
+	default:
+		Log.Warnf("invalid %s %q, executing hooks sequentially", hooksModeEnv, mode)
+		return hooksSequential
+	}
+}
+
+// hooks returns the hooks of the given stage
+func (u *Unikontainer) hooks(name string) []specs.Hook {
+	if u.Spec.Hooks == nil {
+		return nil
+	}
+	return map[string][]specs.Hook{
+		"Prestart":        u.Spec.Hooks.Prestart,
+		"CreateRuntime":   u.Spec.Hooks.CreateRuntime,
+		"CreateContainer": u.Spec.Hooks.CreateContainer,
+		"StartContainer":  u.Spec.Hooks.StartContainer,
+		"Poststart":       u.Spec.Hooks.Poststart,
+		"Poststop":        u.Spec.Hooks.Poststop,
+	}[name]
+}
+
+// hookNetnsPath returns the network namespace that the hooks of the given
+// stage must run in, or an empty string for the namespace of the runtime.
+// According to the OCI spec, the CreateContainer and StartContainer hooks
+// run in the container namespaces.
+func (u *Unikontainer) hookNetnsPath(name string) (string, error) {
+	switch name {
+	case "CreateContainer", "StartContainer":
+		return u.containerNetnsPath()
+	default:
+		return "", nil
+	}
+}
+
+// containerNetnsPath returns the network namespace of the container, which
+// is the one of its sandbox or else the one of the reexec process
+func (u *Unikontainer) containerNetnsPath() (string, error) {
+	netnsPath, err := u.sandboxNetnsPath()
+	if err != nil || netnsPath != "" {
+		return netnsPath, err
+	}
+	if u.State.Pid <= 0 {
+		return "", fmt.Errorf("container %s has no process", u.State.ID)
+	}
+	return fmt.Sprintf("/proc/%d/ns/net", u.State.Pid), nil
+}
+
+// ExecuteHooks executes any hooks found in spec based on name. The hooks
+// are executed sequentially in order, unless concurrent execution is
+// selected with URUNC_HOOKS_MODE=concurrent.
+// More info for individual hooks can be found here:
+// https://github.com/opencontainers/runtime-spec/blob/main/config.md#posix-platform-hooks
+func (u *Unikontainer) ExecuteHooks(name string) error {
+	Log.Infof("Executing %s hooks", name)
+	hooks := u.hooks(name)
+	if len(hooks) == 0 {
+		Log.WithFields(logrus.Fields{
+			"id":   u.State.ID,
+			"name": name,
+		}).Debug("No hooks")
+		return nil
+	}
+
+	s, err := json.Marshal(u.State)
+	if err != nil {
+		return err
+	}
+	netnsPath, err := u.hookNetnsPath(name)
+	if err != nil {
+		return fmt.Errorf("failed to find the namespace of %s hooks: %w", name, err)
+	}
+	if hooksMode() == hooksConcurrent {
+		return u.executeHooksConcurrently(name, hooks, s, netnsPath)
+	}
+	return u.executeHooksSequentially(name, hooks, s, netnsPath)
+}
+
+// executePoststopHooks executes the Poststop hooks once, either from the
+// monitor or from Delete. Their failure is only logged, since the container
+// is already gone.
+func (u *Unikontainer) executePoststopHooks() {
+	if u.runtime.PoststopDone {
+		return
+	}
+	err := u.ExecuteHooks("Poststop")
+	if err != nil {
+		Log.WithError(err).Warn("failed to execute Poststop hooks")
+	}
+	unlock, err := u.lock()
+	if err != nil {
+		Log.WithError(err).Warn("failed to record the execution of Poststop hooks")
+		return
+	}
+	defer unlock()
+	u.runtime.PoststopDone = true
+	err = u.saveContainerState()
+	if err != nil {
+		Log.WithError(err).Warn("failed to record the execution of Poststop hooks")
+	}
+}
+
+// executeHooksSequentially executes the hooks in order and stops at the
+// first one that fails. As the OCI spec requires, the remaining Poststart and
+// Poststop hooks are still executed, and the first error is returned.
+func (u *Unikontainer) executeHooksSequentially(name string, hooks []specs.Hook, state []byte, netnsPath string) error {
+	var firstErr error
+	for _, hook := range hooks {
+		err := u.runHook(name, hook, state, netnsPath)
+		if err == nil {
+			continue
+		}
+		if name != "Poststart" && name != "Poststop" {
+			return err
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// executeHooksConcurrently executes all the hooks at once. Hooks that
+// depend on each other must not be executed this way.
+func (u *Unikontainer) executeHooksConcurrently(name string, hooks []specs.Hook, state []byte, netnsPath string) error {
+	var wg sync.WaitGroup
+	errChan := make(chan error, len(hooks))
+
+	for _, hook := range hooks {
+		wg.Add(1)
+		go func(hook specs.Hook) {
+			defer wg.Done()
+			if err := u.runHook(name, hook, state, netnsPath); err != nil {
+				errChan <- err
+			}
+		}(hook)
+	}
+	wg.Wait()
+	close(errChan)
+	for err := range errChan {
+		Log.WithField("error", err.Error()).Error("failed to execute hooks")
+		return err
+	}
+	return nil
+}
+
+// runHook executes a single hook, passing the state of the container in its
+// stdin. The hook runs in its own process group, so that when its timeout
+// expires, the hook is killed along with any process it spawned. If netnsPath
+// is set, the hook is spawned in that network namespace.
+func (u *Unikontainer) runHook(name string, hook specs.Hook, state []byte, netnsPath string) error {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Cmd{
+		Path:        hook.Path,
+		Args:        hook.Args,
+		Env:         hook.Env,
+		Stdin:       bytes.NewReader(state),
+		Stdout:      &stdout,
+		Stderr:      &stderr,
+		SysProcAttr: &syscall.SysProcAttr{Setpgid: true},
+	}
+
+	Log.WithFields(logrus.Fields{
+		"cmd":   cmd.String(),
+		"path":  hook.Path,
+		"args":  hook.Args,
+		"env":   hook.Env,
+		"netns": netnsPath,
+	}).Infof("executing %s hook", name)
+
+	err := startInNetns(&cmd, netnsPath)
+	if err == nil {
+		err = waitHook(&cmd, hook.Timeout)
+	}
+	if err != nil {
+		Log.WithFields(logrus.Fields{
+			"id":     u.State.ID,
+			"name":   name,
+			"error":  err.Error(),
+			"cmd":    cmd.String(),
+			"stderr": stderr.String(),
+			"stdout": stdout.String(),
+		}).Error("failed to execute hook")
+		return fmt.Errorf("failed to execute %s hook '%s': %w", name, cmd.String(), err)
+	}
+	return nil
+}
+
+// waitHook waits for a started hook. If timeout (in seconds) is set and
+// expires, the process group of the hook is killed and ErrHookTimeout is
+// returned.
+func waitHook(cmd *exec.Cmd, timeout *int) error {
+	if timeout == nil || *timeout <= 0 {
+		return cmd.Wait()
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+	timer := time.NewTimer(time.Duration(*timeout) * time.Second)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+		// The hook is the leader of its process group
+		err := unix.Kill(-cmd.Process.Pid, unix.SIGKILL)
+		if err != nil {
+			Log.WithError(err).Errorf("failed to kill hook process group %d", cmd.Process.Pid)
+		}
+		<-done
+		return fmt.Errorf("%w after %ds", ErrHookTimeout, *timeout)
+	}
+}
+
+// startInNetns starts cmd in the given network namespace. The namespace is
+// only switched for the current thread, which the child inherits on fork.
+func startInNetns(cmd *exec.Cmd, netnsPath string) error {
+	if netnsPath == "" {
+		return cmd.Start()
+	}
+	ns, err := netns.GetFromPath(netnsPath)
+	if err != nil {
+		return fmt.Errorf("failed to get netns %s: %w", netnsPath, err)
+	}
+	defer ns.Close()
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	origNs, err := netns.Get()
+	if err != nil {
+		return fmt.Errorf("failed to get current netns: %w", err)
+	}
+	defer origNs.Close()
+	err = netns.Set(ns)
+	if err != nil {
+		return fmt.Errorf("failed to join netns %s: %w", netnsPath, err)
+	}
+	startErr := cmd.Start()
+	err = netns.Set(origNs)
+	if err != nil {
+		// Do not let another goroutine run in the wrong namespace
+		Log.WithError(err).Error("failed to return to the original netns")
+		runtime.LockOSThread()
+	}
+	return startErr
+}
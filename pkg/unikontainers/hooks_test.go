@@ -0,0 +1,210 @@
+// Copyright (c) 2023-2024, Nubificus LTD
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package unikontainers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/assert"
+)
+
+// shellHook returns a hook that runs the given shell script
+func shellHook(script string, timeout *int) specs.Hook {
+	return specs.Hook{
+		Path:    "/bin/sh",
+		Args:    []string{"sh", "-c", script},
+		Timeout: timeout,
+	}
+}
+
+func TestRunHook(t *testing.T) {
+	t.Run("run hook state on stdin", func(t *testing.T) {
+		u := newTestUnikontainer(t, specs.StateCreated, os.Getpid())
+		out := filepath.Join(t.TempDir(), "state")
+		state, err := json.Marshal(u.State)
+		assert.NoError(t, err)
+
+		err = u.runHook("Poststart", shellHook("cat > "+out, nil), state, "")
+		assert.NoError(t, err)
+		data, err := os.ReadFile(out)
+		assert.NoError(t, err)
+		assert.JSONEq(t, string(state), string(data))
+	})
+
+	t.Run("run hook failure", func(t *testing.T) {
+		u := newTestUnikontainer(t, specs.StateCreated, os.Getpid())
+		err := u.runHook("Poststart", shellHook("exit 3", nil), nil, "")
+		assert.Error(t, err)
+		assert.False(t, errors.Is(err, ErrHookTimeout), "Expected a failure, not a timeout")
+	})
+
+	t.Run("run hook timeout", func(t *testing.T) {
+		u := newTestUnikontainer(t, specs.StateCreated, os.Getpid())
+		timeout := 1
+		// The background process keeps stdout open, so it must be killed too
+		hook := shellHook("sleep 30 & sleep 30", &timeout)
+
+		start := time.Now()
+		err := u.runHook("Poststop", hook, nil, "")
+		assert.True(t, errors.Is(err, ErrHookTimeout), "Expected ErrHookTimeout, got %v", err)
+		assert.Less(t, time.Since(start), 10*time.Second, "Expected the hook to be killed")
+		assert.True(t, strings.Contains(err.Error(), "Poststop hook"), "Expected the hook to be reported")
+		assert.True(t, strings.Contains(err.Error(), "sleep 30"), "Expected the hook to be reported")
+	})
+}
+
+func TestDeleteExecutesPoststopHooks(t *testing.T) {
+	u := newTestUnikontainer(t, specs.StateStopped, 0)
+	out := filepath.Join(t.TempDir(), "poststop")
+	u.Spec.Hooks = &specs.Hooks{
+		Poststop: []specs.Hook{
+			shellHook("exit 1", nil),
+			shellHook("touch "+out, nil),
+		},
+	}
+
+	err := u.Delete()
+	assert.NoError(t, err, "Expected failed Poststop hooks not to fail delete")
+	_, err = os.Stat(out)
+	assert.NoError(t, err, "Expected Poststop hook to run")
+	_, err = os.Stat(u.BaseDir)
+	assert.True(t, os.IsNotExist(err), "Expected container directory to be removed")
+}
+
+func TestExecuteHooksOrder(t *testing.T) {
+	t.Setenv(hooksModeEnv, "")
+	out := filepath.Join(t.TempDir(), "order")
+	var hooks []specs.Hook
+	for i := 1; i <= 5; i++ {
+		// Earlier hooks take longer, so that concurrent execution
+		// would reverse the order
+		hooks = append(hooks, shellHook(fmt.Sprintf("sleep 0.%d; echo %d >> %s", 6-i, i, out), nil))
+	}
+
+	u := newTestUnikontainer(t, specs.StateCreating, 0)
+	u.Spec.Hooks = &specs.Hooks{CreateRuntime: hooks}
+	err := u.ExecuteHooks("CreateRuntime")
+	assert.NoError(t, err)
+	data, err := os.ReadFile(out)
+	assert.NoError(t, err)
+	assert.Equal(t, "1\n2\n3\n4\n5\n", string(data))
+}
+
+func TestExecuteHooksFailure(t *testing.T) {
+	t.Setenv(hooksModeEnv, "")
+	tests := []struct {
+		name     string
+		expected string
+	}{
+		// Hooks of most stages stop at the first failure
+		{name: "CreateRuntime", expected: "1\n"},
+		// Poststop hooks must all run
+		{name: "Poststop", expected: "1\n3\n"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			out := filepath.Join(t.TempDir(), "order")
+			hooks := []specs.Hook{
+				shellHook("echo 1 >> "+out, nil),
+				shellHook("exit 1", nil),
+				shellHook("echo 3 >> "+out, nil),
+			}
+			u := newTestUnikontainer(t, specs.StateStopped, 0)
+			u.Spec.Hooks = &specs.Hooks{CreateRuntime: hooks, Poststop: hooks}
+
+			err := u.ExecuteHooks(tc.name)
+			assert.Error(t, err)
+			data, err := os.ReadFile(out)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, string(data))
+		})
+	}
+}
+
+func TestHooksMode(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected string
+	}{
+		{value: "", expected: hooksSequential},
+		{value: "sequential", expected: hooksSequential},
+		{value: "concurrent", expected: hooksConcurrent},
+		{value: "parallel", expected: hooksSequential},
+	}
+	for _, tc := range tests {
+		t.Setenv(hooksModeEnv, tc.value)
+		assert.Equal(t, tc.expected, hooksMode(), "Unexpected mode for %q", tc.value)
+	}
+}
+
+func TestExecuteHooksNamespace(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("creating a network namespace requires root")
+	}
+	// A process in its own network namespace plays the reexec process
+	cmd := exec.Command("sleep", "30")
+	cmd.SysProcAttr = &syscall.SysProcAttr{Cloneflags: syscall.CLONE_NEWNET}
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("Failed to start process in new netns: %v", err)
+	}
+	defer func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	}()
+	containerNetns, err := os.Readlink(fmt.Sprintf("/proc/%d/ns/net", cmd.Process.Pid))
+	assert.NoError(t, err)
+	hostNetns, err := os.Readlink("/proc/self/ns/net")
+	assert.NoError(t, err)
+
+	for _, mode := range []string{hooksSequential, hooksConcurrent} {
+		for _, name := range []string{"CreateRuntime", "CreateContainer", "StartContainer", "Poststop"} {
+			t.Run(mode+" "+name, func(t *testing.T) {
+				t.Setenv(hooksModeEnv, mode)
+				out := filepath.Join(t.TempDir(), "netns")
+				hook := []specs.Hook{shellHook("readlink /proc/self/ns/net > "+out, nil)}
+				u := newTestUnikontainer(t, specs.StateCreated, cmd.Process.Pid)
+				u.Spec.Hooks = &specs.Hooks{
+					CreateRuntime:   hook,
+					CreateContainer: hook,
+					StartContainer:  hook,
+					Poststop:        hook,
+				}
+
+				err := u.ExecuteHooks(name)
+				assert.NoError(t, err)
+				data, err := os.ReadFile(out)
+				assert.NoError(t, err)
+				expected := hostNetns
+				if name == "CreateContainer" || name == "StartContainer" {
+					expected = containerNetns
+				}
+				assert.Equal(t, expected, strings.TrimSpace(string(data)))
+			})
+		}
+	}
+	current, err := os.Readlink("/proc/self/ns/net")
+	assert.NoError(t, err)
+	assert.Equal(t, hostNetns, current, "Expected the runtime to stay in its own netns")
+}
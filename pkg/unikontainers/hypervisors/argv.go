@@ -0,0 +1,84 @@
+// Copyright (c) 2023-2024, Nubificus LTD
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hypervisors
+
+import (
+	"strconv"
+	"strings"
+)
+
+// argvBuilder builds the argument vector of a VMM process. Every value is
+// kept in a single argument, so paths and guest command lines that contain
+// spaces reach the VMM intact.
+type argvBuilder struct {
+	args []string
+}
+
+// newArgv starts an argument vector with the path of the VMM binary
+func newArgv(path string) *argvBuilder {
+	return &argvBuilder{args: []string{path}}
+}
+
+// Arg appends each of the given arguments as is
+func (b *argvBuilder) Arg(args ...string) *argvBuilder {
+	b.args = append(b.args, args...)
+	return b
+}
+
+// Flag appends a flag followed by its value as a separate argument
+// (e.g. "-kernel /unikernel")
+func (b *argvBuilder) Flag(name string, value string) *argvBuilder {
+	b.args = append(b.args, name, value)
+	return b
+}
+
+// Option appends a flag and its value as a single argument
+// (e.g. "--mem=256")
+func (b *argvBuilder) Option(name string, value string) *argvBuilder {
+	b.args = append(b.args, name+"="+value)
+	return b
+}
+
+// OptionNonEmpty appends the option only if value is not empty
+func (b *argvBuilder) OptionNonEmpty(name string, value string) *argvBuilder {
+	if value == "" {
+		return b
+	}
+	return b.Option(name, value)
+}
+
+// Argv returns the argument vector, ready to be passed to execve
+func (b *argvBuilder) Argv() []string {
+	return b.args
+}
+
+// String returns the command line for logging, quoting any argument that
+// would otherwise be ambiguous
+func (b *argvBuilder) String() string {
+	quoted := make([]string, len(b.args))
+	for i, arg := range b.args {
+		if arg == "" || strings.ContainsAny(arg, " \t\n\"'\\") {
+			arg = strconv.Quote(arg)
+		}
+		quoted[i] = arg
+	}
+	return strings.Join(quoted, " ")
+}
+
+// qemuOptionValue escapes a value of a QEMU option list, where a literal
+// comma is written as two
+func qemuOptionValue(value string) string {
+	return strings.ReplaceAll(value, ",", ",,")
+}
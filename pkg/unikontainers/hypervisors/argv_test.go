@@ -0,0 +1,158 @@
+// Copyright (c) 2023-2024, Nubificus LTD
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hypervisors
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+// assertGolden compares v, marshalled to JSON, with the golden file
+// testdata/<name>.golden
+func assertGolden(t *testing.T, name string, v interface{}) {
+	t.Helper()
+	data, err := json.MarshalIndent(v, "", "  ")
+	assert.NoError(t, err)
+	data = append(data, '\n')
+	golden := filepath.Join("testdata", name+".golden")
+	if *update {
+		assert.NoError(t, os.WriteFile(golden, data, 0o644))
+	}
+	expected, err := os.ReadFile(golden)
+	assert.NoError(t, err)
+	assert.Equal(t, string(expected), string(data))
+}
+
+// goldenExecArgs returns the ExecArgs of the golden tests. With spaces set,
+// the paths and the guest command line contain spaces, which must reach the
+// VMM intact.
+func goldenExecArgs(spaces bool) ExecArgs {
+	args := ExecArgs{
+		Container:     "golden",
+		UnikernelPath: "/bundle/rootfs/unikernel",
+		TapDevice:     "tap0_urunc",
+		BlockDevice:   "/dev/mapper/golden",
+		InitrdPath:    "/bundle/rootfs/initrd",
+		Command:       "app -v",
+		IPAddress:     "10.0.0.2",
+		GuestMAC:      "aa:bb:cc:dd:ee:ff",
+		MemSizeB:      512 * 1000 * 1000,
+		StateDir:      "/run/urunc/golden",
+		RootDevice:    true,
+		Volumes: []Volume{
+			{Path: "/dev/mapper/data"},
+			{Path: "/images/ro.img", ReadOnly: true},
+		},
+	}
+	if spaces {
+		args.UnikernelPath = "/my bundle/rootfs/unikernel"
+		args.InitrdPath = "/my bundle/rootfs/initrd"
+		args.Command = `app --name "hello world"  --path=/a,b`
+		args.StateDir = "/run/urunc/my golden"
+		args.BlockDevice = ""
+		args.RootDevice = false
+		args.Volumes = []Volume{{Path: "/my images/data.img", ReadOnly: true}}
+		args.Seccomp = true
+	}
+	return args
+}
+
+func TestArgvGolden(t *testing.T) {
+	if runtime.GOARCH != "amd64" {
+		t.Skip("the golden files are generated for amd64")
+	}
+	tests := []struct {
+		name string
+		argv func(ExecArgs) []string
+	}{
+		{name: "hvt", argv: func(args ExecArgs) []string {
+			return (&HVT{binaryPath: "/usr/bin/solo5-hvt"}).command(args).Argv()
+		}},
+		{name: "spt", argv: func(args ExecArgs) []string {
+			return (&SPT{binaryPath: "/usr/bin/solo5-spt"}).command(args).Argv()
+		}},
+		{name: "qemu", argv: func(args ExecArgs) []string {
+			return (&Qemu{binaryPath: "/usr/bin/qemu-system-x86_64"}).command(args).Argv()
+		}},
+		{name: "firecracker", argv: func(args ExecArgs) []string {
+			return (&Firecracker{binaryPath: "/usr/bin/firecracker"}).command(args, "/run/urunc/golden/fc.json").Argv()
+		}},
+		{name: "cloud-hypervisor", argv: func(args ExecArgs) []string {
+			cmd, err := (&CloudHypervisor{binaryPath: "/usr/bin/cloud-hypervisor"}).command(args)
+			assert.NoError(t, err)
+			return cmd.Argv()
+		}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assertGolden(t, tc.name, tc.argv(goldenExecArgs(false)))
+		})
+		t.Run(tc.name+" spaces", func(t *testing.T) {
+			assertGolden(t, tc.name+"-spaces", tc.argv(goldenExecArgs(true)))
+		})
+	}
+}
+
+func TestFirecrackerConfigGolden(t *testing.T) {
+	if runtime.GOARCH != "amd64" {
+		t.Skip("the golden files are generated for amd64")
+	}
+	fc := &Firecracker{binaryPath: "/usr/bin/firecracker"}
+	assertGolden(t, "firecracker-config", fc.config(goldenExecArgs(false)))
+	assertGolden(t, "firecracker-config-spaces", fc.config(goldenExecArgs(true)))
+	args := goldenExecArgs(false)
+	args.APIBoot = true
+	assertGolden(t, "firecracker-config-api", fc.config(args))
+}
+
+func TestFirecrackerJailerGolden(t *testing.T) {
+	if runtime.GOARCH != "amd64" {
+		t.Skip("the golden files are generated for amd64")
+	}
+	fc := &Firecracker{binaryPath: "/usr/bin/firecracker"}
+	args := goldenExecArgs(false)
+	args.Jailer = &JailerConfig{
+		UID:           1000,
+		GID:           1000,
+		ChrootBaseDir: "/srv/jailer",
+		ParentCgroup:  "urunc",
+		CgroupVersion: 2,
+	}
+	jailed := jailedArgs(args)
+	cmd := fc.jailerCommand("/usr/bin/jailer", args, fc.command(jailed, "/fc.json"))
+	assertGolden(t, "firecracker-jailer", cmd.Argv())
+	assertGolden(t, "firecracker-jailer-config", fc.config(jailed))
+	assert.Equal(t, "/srv/jailer/firecracker/golden", fc.jailDir(args))
+}
+
+func TestCloudHypervisorCommas(t *testing.T) {
+	args := goldenExecArgs(false)
+	args.BlockDevice = "/dev/mapper/a,b"
+	_, err := (&CloudHypervisor{binaryPath: "/usr/bin/cloud-hypervisor"}).command(args)
+	assert.Error(t, err, "Expected a block device with a comma to be rejected")
+}
+
+func TestArgvString(t *testing.T) {
+	cmd := newArgv("/usr/bin/vmm").Flag("-kernel", "/my bundle/unikernel").Option("--mem", "256").Arg("")
+	assert.Equal(t, `/usr/bin/vmm -kernel "/my bundle/unikernel" --mem=256 ""`, cmd.String())
+}
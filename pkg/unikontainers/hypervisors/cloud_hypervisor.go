@@ -0,0 +1,171 @@
+// Copyright (c) 2023-2024, Nubificus LTD
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hypervisors
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const (
+	CloudHypervisorVmm    VmmType = "cloud-hypervisor"
+	CloudHypervisorBinary string  = "cloud-hypervisor"
+
+	// The exit code of a Rust panic, which Cloud Hypervisor exits with
+	// when it crashes
+	chExitPanic = 101
+)
+
+type CloudHypervisor struct {
+	binaryPath string
+	binary     string
+}
+
+// Stop shuts down the guest and then terminates Cloud Hypervisor, through
+// its API socket in the given container's state directory
+func (ch *CloudHypervisor) Stop(stateDir string) error {
+	client, err := newCloudHypervisorClient(stateDir)
+	if err != nil {
+		return err
+	}
+	err = client.put("vm.shutdown")
+	if err != nil {
+		// The VMM can still be terminated, even if the guest was
+		// not running
+		vmmLog.WithError(err).Warn("failed to shut down the Cloud Hypervisor guest")
+	}
+	return client.put("vmm.shutdown")
+}
+
+// Shutdown sends an ACPI power button event to the guest through the API
+// socket of Cloud Hypervisor. Guests that do not handle it keep running until
+// they are stopped.
+func (ch *CloudHypervisor) Shutdown(stateDir string) error {
+	client, err := newCloudHypervisorClient(stateDir)
+	if err != nil {
+		return err
+	}
+	return client.put("vm.power-button")
+}
+
+// Pause pauses the guest through the API socket of Cloud Hypervisor
+func (ch *CloudHypervisor) Pause(stateDir string) error {
+	client, err := newCloudHypervisorClient(stateDir)
+	if err != nil {
+		return err
+	}
+	return client.put("vm.pause")
+}
+
+// Resume resumes the guest through the API socket of Cloud Hypervisor
+func (ch *CloudHypervisor) Resume(stateDir string) error {
+	client, err := newCloudHypervisorClient(stateDir)
+	if err != nil {
+		return err
+	}
+	return client.put("vm.resume")
+}
+
+// ExitStatus translates the exit code of Cloud Hypervisor. Cloud Hypervisor
+// does not pass the status of the guest on: it exits with 0 whenever the
+// guest shuts down. A panic of the VMM is reported as a crash.
+func (ch *CloudHypervisor) ExitStatus(code int) (int, bool) {
+	if code == chExitPanic {
+		return ExitStatusGuestCrash, true
+	}
+	return code, false
+}
+
+func (ch *CloudHypervisor) Ok() error {
+	return nil
+}
+
+func (ch *CloudHypervisor) Path() string {
+	return ch.binaryPath
+}
+
+// command builds the Cloud Hypervisor command line. The values of the
+// options that take a list of key=value pairs can not contain commas.
+func (ch *CloudHypervisor) command(args ExecArgs) (*argvBuilder, error) {
+	cmd := newArgv(ch.binaryPath)
+	if args.StateDir != "" {
+		cmd.Flag("--api-socket", "path="+chSockPath(args.StateDir))
+	}
+	// TODO: Use value from configuration or Environment variable
+	cmd.Flag("--cpus", "boot=1").
+		Flag("--memory", "size="+bytesToStringMB(args.MemSizeB)+"M").
+		Flag("--kernel", args.UnikernelPath)
+	if args.InitrdPath != "" {
+		cmd.Flag("--initramfs", args.InitrdPath)
+	}
+	cmd.Flag("--cmdline", args.Command)
+	if args.TapDevice != "" {
+		net := "tap=" + args.TapDevice
+		if args.GuestMAC != "" {
+			net += ",mac=" + args.GuestMAC
+		}
+		cmd.Flag("--net", net)
+	}
+	// All the disks are given to a single --disk option
+	var disks []string
+	values := []string{args.TapDevice, args.GuestMAC, args.BlockDevice, args.StateDir}
+	if args.BlockDevice != "" {
+		disks = append(disks, chDisk(args.BlockDevice, args.BlockDeviceRO))
+	}
+	for _, volume := range args.Volumes {
+		disks = append(disks, chDisk(volume.Path, volume.ReadOnly))
+		values = append(values, volume.Path)
+	}
+	if len(disks) > 0 {
+		cmd.Arg("--disk").Arg(disks...)
+	}
+	for _, value := range values {
+		if strings.Contains(value, ",") {
+			return nil, fmt.Errorf("cloud-hypervisor does not support commas in option values: %q", value)
+		}
+	}
+	// The guest console is the serial port, which is connected to the
+	// stdio of the container
+	cmd.Flag("--serial", "tty").
+		Flag("--console", "off").
+		Flag("--seccomp", strconv.FormatBool(args.Seccomp))
+	return cmd, nil
+}
+
+// chDisk returns the value of the --disk option for a block device
+func chDisk(path string, readOnly bool) string {
+	if readOnly {
+		return "path=" + path + ",readonly=on"
+	}
+	return "path=" + path
+}
+
+func (ch *CloudHypervisor) Execve(args ExecArgs) error {
+	if args.StateDir != "" {
+		sockPath := chSockPath(args.StateDir)
+		if err := os.Remove(sockPath); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove stale Cloud Hypervisor API socket: %w", err)
+		}
+	}
+	cmd, err := ch.command(args)
+	if err != nil {
+		return err
+	}
+	vmmLog.WithField("cloud-hypervisor command", cmd.String()).Info("Ready to execve cloud-hypervisor")
+	return syscall.Exec(ch.Path(), cmd.Argv(), args.Environment) //nolint: gosec
+}
@@ -0,0 +1,90 @@
+// Copyright (c) 2023-2024, Nubificus LTD
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hypervisors
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	CHSockFilename = "ch.sock"
+	chAPITimeout   = 5 * time.Second
+)
+
+// cloudHypervisorClient is a minimal client for the REST API of Cloud
+// Hypervisor, which is served over a Unix socket
+type cloudHypervisorClient struct {
+	client *http.Client
+}
+
+// chSockPath returns the path of the API socket of a Cloud Hypervisor
+// instance, based on the container's state directory
+func chSockPath(stateDir string) string {
+	return filepath.Join(stateDir, CHSockFilename)
+}
+
+// newCloudHypervisorClient returns a client for the API socket of the Cloud
+// Hypervisor instance of a container. If Cloud Hypervisor was not started
+// with an API socket, it returns ErrNotSupported.
+func newCloudHypervisorClient(stateDir string) (*cloudHypervisorClient, error) {
+	sockPath := chSockPath(stateDir)
+	_, err := os.Stat(sockPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotSupported
+		}
+		return nil, err
+	}
+	return &cloudHypervisorClient{
+		client: &http.Client{
+			Timeout: chAPITimeout,
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, "unix", sockPath)
+				},
+			},
+		},
+	}, nil
+}
+
+// put sends a request without a body to the given endpoint of the API
+// (e.g. vm.shutdown)
+func (c *cloudHypervisorClient) put(endpoint string) error {
+	// The host part is ignored, since we always dial the Unix socket
+	req, err := http.NewRequest(http.MethodPut, "http://localhost/api/v1/"+endpoint, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("cloud-hypervisor API request %s failed: %w", endpoint, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("cloud-hypervisor API request %s failed with status %d: %s",
+			endpoint, resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
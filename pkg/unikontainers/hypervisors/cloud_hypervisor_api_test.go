@@ -0,0 +1,76 @@
+// Copyright (c) 2023-2024, Nubificus LTD
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hypervisors
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// serveCloudHypervisor serves the Cloud Hypervisor API on the socket of the
+// state directory and returns a function that lists the requests it received
+func serveCloudHypervisor(t *testing.T, stateDir string) func() []string {
+	t.Helper()
+	var mu sync.Mutex
+	var requests []string
+	listener, err := net.Listen("unix", chSockPath(stateDir))
+	assert.NoError(t, err)
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { //nolint: gosec
+		mu.Lock()
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	})}
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	t.Cleanup(func() {
+		_ = server.Close()
+	})
+	return func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), requests...)
+	}
+}
+
+func TestCloudHypervisorStop(t *testing.T) {
+	ch := &CloudHypervisor{}
+
+	t.Run("shutdown presses the power button", func(t *testing.T) {
+		stateDir := t.TempDir()
+		recorded := serveCloudHypervisor(t, stateDir)
+		err := ch.Shutdown(stateDir)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"PUT /api/v1/vm.power-button"}, recorded())
+	})
+
+	t.Run("stop shuts down the guest and the vmm", func(t *testing.T) {
+		stateDir := t.TempDir()
+		recorded := serveCloudHypervisor(t, stateDir)
+		err := ch.Stop(stateDir)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"PUT /api/v1/vm.shutdown", "PUT /api/v1/vmm.shutdown"}, recorded())
+	})
+
+	t.Run("no api socket", func(t *testing.T) {
+		err := ch.Stop(t.TempDir())
+		assert.ErrorIs(t, err, ErrNotSupported)
+	})
+}
@@ -15,19 +15,40 @@
 package hypervisors
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"runtime"
-	"strings"
 	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
 )
 
 const (
 	FirecrackerVmm    VmmType = "firecracker"
 	FirecrackerBinary string  = "firecracker"
 	FCJsonFilename    string  = "fc.json"
+	// The time the guest has to shut down on Stop, before Firecracker is killed
+	fcStopTimeout = 5 * time.Second
+	// The interval of the statistics of the balloon device, in seconds
+	fcBalloonStatsInterval = 1
+)
+
+// The exit codes Firecracker uses when it is terminated by a fault, either
+// a system call blocked by seccomp or a fatal signal
+const (
+	fcExitBadSyscall = 148
+	fcExitSIGBUS     = 149
+	fcExitSIGSEGV    = 150
+	fcExitSIGXFSZ    = 151
+	fcExitSIGXCPU    = 154
+	fcExitSIGPIPE    = 155
+	fcExitSIGHUP     = 156
+	fcExitSIGILL     = 157
 )
 
 type Firecracker struct {
@@ -61,17 +82,234 @@ type FirecrackerNet struct {
 	HostIF   string `json:"host_dev_name"`
 }
 
+type FirecrackerMetrics struct {
+	MetricsPath string `json:"metrics_path"`
+}
+
+type FirecrackerBalloon struct {
+	AmountMiB             uint64 `json:"amount_mib"`
+	DeflateOnOOM          bool   `json:"deflate_on_oom"`
+	StatsPollingIntervalS int    `json:"stats_polling_interval_s"`
+}
+
 type FirecrackerConfig struct {
 	Source  FirecrackerBootSource `json:"boot-source"`
 	Machine FirecrackerMachine    `json:"machine-config"`
 	Drives  []FirecrackerDrive    `json:"drives"`
 	NetIfs  []FirecrackerNet      `json:"network-interfaces"`
+	Metrics *FirecrackerMetrics   `json:"metrics,omitempty"`
+	Balloon *FirecrackerBalloon   `json:"balloon,omitempty"`
+}
+
+// Stop asks the guest to shut down with Ctrl+Alt+Del and kills Firecracker,
+// if it has not exited within fcStopTimeout. Then it tears down the jail of
+// Firecracker, if any. Without an API socket, Firecracker can not be stopped.
+func (fc *Firecracker) Stop(stateDir string) error {
+	err := fc.stop(stateDir)
+	jailErr := removeJail(stateDir)
+	if err != nil {
+		return err
+	}
+	return jailErr
 }
 
-func (fc *Firecracker) Stop(_ string) error {
+func (fc *Firecracker) stop(stateDir string) error {
+	client, err := newFirecrackerClient(stateDir)
+	if err != nil {
+		return err
+	}
+	pid, err := client.pid()
+	if err != nil {
+		// Nobody listens on the socket, but Firecracker might still be
+		// running (e.g. it has not served it yet), so it must be signaled
+		return fmt.Errorf("failed to find the Firecracker process: %w", err)
+	}
+	pidfd, err := unix.PidfdOpen(pid, 0)
+	if err != nil {
+		if errors.Is(err, unix.ESRCH) {
+			return nil
+		}
+		return err
+	}
+	defer unix.Close(pidfd)
+
+	err = client.action(fcActionSendCtrlAltDel)
+	if err == nil {
+		// The pidfd becomes readable once the process exits
+		fds := []unix.PollFd{{Fd: int32(pidfd), Events: unix.POLLIN}}
+		n, err := unix.Poll(fds, int(fcStopTimeout.Milliseconds()))
+		if err == nil && n > 0 {
+			return nil
+		}
+		vmmLog.Warn("the guest did not shut down, killing Firecracker")
+	} else {
+		vmmLog.WithError(err).Warn("failed to send Ctrl+Alt+Del, killing Firecracker")
+	}
+	err = unix.PidfdSendSignal(pidfd, unix.SIGKILL, nil, 0)
+	if err != nil && !errors.Is(err, unix.ESRCH) {
+		return err
+	}
 	return nil
 }
 
+// Cleanup tears down the jail of the Firecracker instance, if any
+func (fc *Firecracker) Cleanup(stateDir string) error {
+	return removeJail(stateDir)
+}
+
+// Shutdown asks the guest to shut down, by sending Ctrl+Alt+Del through the
+// Firecracker API. Only x86 guests handle it, through the i8042 controller.
+func (fc *Firecracker) Shutdown(stateDir string) error {
+	client, err := newFirecrackerClient(stateDir)
+	if err != nil {
+		return err
+	}
+	return client.action(fcActionSendCtrlAltDel)
+}
+
+// savedConfig reads the configuration of the microVM that Execve saved
+func savedConfig(stateDir string) (*FirecrackerConfig, error) {
+	data, err := os.ReadFile(fcFilePath(stateDir, FCJsonFilename))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read Firecracker json config: %w", err)
+	}
+	var config FirecrackerConfig
+	err = json.Unmarshal(data, &config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse Firecracker json config: %w", err)
+	}
+	return &config, nil
+}
+
+// Boot configures the microVM through the Firecracker API, with the
+// configuration that Execve saved, and starts it
+func (fc *Firecracker) Boot(stateDir string) error {
+	config, err := savedConfig(stateDir)
+	if err != nil {
+		return err
+	}
+	client, err := waitFirecrackerClient(stateDir, fcAPITimeout)
+	if err != nil {
+		return err
+	}
+	return client.boot(config)
+}
+
+// Metrics flushes the metrics of Firecracker and returns them. Only a
+// Firecracker instance that was booted through the API reports metrics.
+func (fc *Firecracker) Metrics(stateDir string) (json.RawMessage, error) {
+	metricsPath := fcFilePath(stateDir, FCMetricsFilename)
+	_, err := os.Stat(metricsPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotSupported
+		}
+		return nil, err
+	}
+	client, err := newFirecrackerClient(stateDir)
+	if err != nil {
+		return nil, err
+	}
+	err = client.action(fcActionFlushMetrics)
+	if err != nil {
+		return nil, err
+	}
+	// Firecracker appends a line of metrics on every flush
+	data, err := os.ReadFile(metricsPath)
+	if err != nil {
+		return nil, err
+	}
+	data = bytes.TrimSpace(data)
+	if i := bytes.LastIndexByte(data, '\n'); i >= 0 {
+		data = data[i+1:]
+	}
+	if !json.Valid(data) {
+		return nil, fmt.Errorf("invalid Firecracker metrics in %s", metricsPath)
+	}
+	return data, nil
+}
+
+// SetMemory inflates or deflates the balloon device of the microVM, so that
+// the guest is left with sizeB bytes of its memory. Only a Firecracker
+// instance that was booted through the API has a balloon device.
+func (fc *Firecracker) SetMemory(stateDir string, sizeB uint64) error {
+	config, err := savedConfig(stateDir)
+	if err != nil {
+		return err
+	}
+	if config.Balloon == nil {
+		return ErrNotSupported
+	}
+	client, err := newFirecrackerClient(stateDir)
+	if err != nil {
+		return err
+	}
+	var amountMiB uint64
+	if sizeMiB := bytesToMiB(sizeB); sizeMiB < config.Machine.MemSizeMiB {
+		amountMiB = config.Machine.MemSizeMiB - sizeMiB
+	}
+	return client.setBalloon(amountMiB)
+}
+
+// Pause pauses the microVM through the Firecracker API
+func (fc *Firecracker) Pause(stateDir string) error {
+	client, err := newFirecrackerClient(stateDir)
+	if err != nil {
+		return err
+	}
+	return client.setVMState(fcVMStatePaused)
+}
+
+// Resume resumes the microVM through the Firecracker API
+func (fc *Firecracker) Resume(stateDir string) error {
+	client, err := newFirecrackerClient(stateDir)
+	if err != nil {
+		return err
+	}
+	return client.setVMState(fcVMStateResumed)
+}
+
+// Snapshot pauses the microVM and saves a full snapshot of it in imageDir.
+// The microVM remains paused.
+func (fc *Firecracker) Snapshot(stateDir string, imageDir string) error {
+	// A jailed Firecracker can not reach imageDir from its chroot
+	if isJailed(stateDir) {
+		return ErrNotSupported
+	}
+	client, err := newFirecrackerClient(stateDir)
+	if err != nil {
+		return err
+	}
+	err = client.setVMState(fcVMStatePaused)
+	if err != nil {
+		return err
+	}
+	return client.createSnapshot(filepath.Join(imageDir, FCSnapshotFilename), filepath.Join(imageDir, FCMemFilename))
+}
+
+// Restore loads the snapshot in imageDir into a Firecracker instance that
+// was started with ExecArgs.SnapshotDir and resumes the microVM
+func (fc *Firecracker) Restore(stateDir string, imageDir string) error {
+	client, err := waitFirecrackerClient(stateDir, fcAPITimeout)
+	if err != nil {
+		return err
+	}
+	return client.loadSnapshot(filepath.Join(imageDir, FCSnapshotFilename), filepath.Join(imageDir, FCMemFilename))
+}
+
+// ExitStatus translates the exit code of Firecracker. Firecracker does not
+// pass the status of the guest on: it exits with 0 whenever the guest shuts
+// down, resets or triple faults. Its own faults are reported as a crash.
+func (fc *Firecracker) ExitStatus(code int) (int, bool) {
+	switch code {
+	case fcExitBadSyscall, fcExitSIGBUS, fcExitSIGSEGV, fcExitSIGXFSZ,
+		fcExitSIGXCPU, fcExitSIGPIPE, fcExitSIGHUP, fcExitSIGILL:
+		return ExitStatusGuestCrash, true
+	default:
+		return code, false
+	}
+}
+
 func (fc *Firecracker) Ok() error {
 	return nil
 }
@@ -79,15 +317,28 @@ func (fc *Firecracker) Path() string {
 	return fc.binaryPath
 }
 
-func (fc *Firecracker) Execve(args ExecArgs) error {
-	cmdString := fc.Path() + " --no-api --config-file "
-	JSONConfigDir := filepath.Dir(args.UnikernelPath)
-	JSONConfigFile := filepath.Join(JSONConfigDir, FCJsonFilename)
-	cmdString += JSONConfigFile
+// command builds the Firecracker command line. Without a configFile, the
+// microVM is configured through the API (e.g. to restore a snapshot).
+func (fc *Firecracker) command(args ExecArgs, configFile string) *argvBuilder {
+	cmd := newArgv(fc.Path())
+	if args.StateDir != "" {
+		// Expose the API in the container's state directory, so that
+		// the microVM can be paused, snapshotted and restored
+		cmd.Flag("--api-sock", fcSockPath(args.StateDir))
+	} else {
+		cmd.Arg("--no-api")
+	}
 	if !args.Seccomp {
-		cmdString += " --no-seccomp"
+		cmd.Arg("--no-seccomp")
 	}
+	if configFile != "" {
+		cmd.Flag("--config-file", configFile)
+	}
+	return cmd
+}
 
+// config builds the configuration of the microVM
+func (fc *Firecracker) config(args ExecArgs) *FirecrackerConfig {
 	// VM config for Firecracker
 	fcMem := DefaultMemory
 	if args.MemSizeB != 0 {
@@ -113,36 +364,99 @@ func (fc *Firecracker) Execve(args ExecArgs) error {
 	}
 	FCNet = append(FCNet, AnIF)
 
-	// Block config for Firecracker
-	// TODO: Add support for block devices in FIrecracker
-	FCDrives := make([]FirecrackerDrive, 0)
+	// Block config for Firecracker. Firecracker adds root= to the boot
+	// arguments of the guest for the root device.
+	FCDrives := make([]FirecrackerDrive, 0, len(args.Volumes)+1)
+	if args.BlockDevice != "" {
+		FCDrives = append(FCDrives, FirecrackerDrive{
+			DriveID:   "rootfs",
+			IsRO:      args.BlockDeviceRO,
+			IsRootDev: args.RootDevice,
+			HostPath:  args.BlockDevice,
+		})
+	}
+	for i, volume := range args.Volumes {
+		FCDrives = append(FCDrives, FirecrackerDrive{
+			DriveID:  fmt.Sprintf("vol%d", i),
+			IsRO:     volume.ReadOnly,
+			HostPath: volume.Path,
+		})
+	}
 
 	// TODO: Check if this check causes any performance drop
 	// or explore alternative implementations
+	bootArgs := args.Command
 	if runtime.GOARCH == "arm64" {
-		consoleStr := " console=ttyS0"
-		args.Command += consoleStr
+		bootArgs += " console=ttyS0"
 	}
 
 	FCSource := FirecrackerBootSource{
 		ImagePath:  args.UnikernelPath,
-		BootArgs:   args.Command,
+		BootArgs:   bootArgs,
 		InitrdPath: args.InitrdPath,
 	}
-	FCConfig := &FirecrackerConfig{
+	config := &FirecrackerConfig{
 		Source:  FCSource,
 		Machine: FCMachine,
 		Drives:  FCDrives,
 		NetIfs:  FCNet,
 	}
-	FCConfigJSON, _ := json.Marshal(FCConfig)
-	if err := os.WriteFile(JSONConfigFile, FCConfigJSON, 0o644); err != nil { //nolint: gosec
+	// A microVM booted through the API is controlled at runtime, so it
+	// reports metrics and gets a balloon to adjust its memory
+	if args.APIBoot {
+		config.Metrics = &FirecrackerMetrics{MetricsPath: filepath.Join(args.StateDir, FCMetricsFilename)}
+		config.Balloon = &FirecrackerBalloon{
+			DeflateOnOOM:          true,
+			StatsPollingIntervalS: fcBalloonStatsInterval,
+		}
+	}
+	return config
+}
+
+func (fc *Firecracker) Execve(args ExecArgs) error {
+	if args.APIBoot && args.StateDir == "" {
+		return fmt.Errorf("cannot boot Firecracker through the API without a state directory")
+	}
+	if args.StateDir != "" {
+		sockPath := fcSockPath(args.StateDir)
+		if err := os.Remove(sockPath); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove stale Firecracker API socket: %w", err)
+		}
+	}
+	if args.Jailer != nil {
+		return fc.execveJailed(args)
+	}
+	if args.SnapshotDir != "" {
+		// The microVM is not booted, it will be configured by loading
+		// the snapshot through the API
+		cmd := fc.command(args, "")
+		vmmLog.WithField("Firecracker command", cmd.String()).Info("Ready to execve Firecracker for snapshot restore")
+		return syscall.Exec(fc.Path(), cmd.Argv(), args.Environment) //nolint: gosec
+	}
+	// Keep the config out of the rootfs, which might be read-only
+	// or shared with other containers
+	JSONConfigDir := args.StateDir
+	if JSONConfigDir == "" {
+		JSONConfigDir = filepath.Dir(args.UnikernelPath)
+	}
+	JSONConfigFile := filepath.Join(JSONConfigDir, FCJsonFilename)
+	FCConfigJSON, _ := json.Marshal(fc.config(args))
+	if err := os.WriteFile(JSONConfigFile, FCConfigJSON, 0o600); err != nil {
 		return fmt.Errorf("failed to save Firecracker json config: %w", err)
 	}
 	vmmLog.WithField("Json=", string(FCConfigJSON)).Info("Firecracker json config")
 
-	exArgs := strings.Split(cmdString, " ")
-	vmmLog.WithField("Firecracker command", exArgs).Info("Ready to execve Firecracker")
+	if args.APIBoot {
+		// Firecracker only opens an existing metrics file
+		err := os.WriteFile(filepath.Join(args.StateDir, FCMetricsFilename), nil, 0o600)
+		if err != nil {
+			return fmt.Errorf("failed to create Firecracker metrics file: %w", err)
+		}
+		// The microVM is configured and booted by Boot
+		JSONConfigFile = ""
+	}
+	cmd := fc.command(args, JSONConfigFile)
+	vmmLog.WithField("Firecracker command", cmd.String()).Info("Ready to execve Firecracker")
 
-	return syscall.Exec(fc.Path(), exArgs, args.Environment) //nolint: gosec
+	return syscall.Exec(fc.Path(), cmd.Argv(), args.Environment) //nolint: gosec
 }
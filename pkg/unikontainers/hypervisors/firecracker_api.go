@@ -0,0 +1,279 @@
+// Copyright (c) 2023-2024, Nubificus LTD
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hypervisors
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	FCSockFilename     = "fc.sock"
+	fcAPITimeout       = 5 * time.Second
+	fcSockPollInterval = 10 * time.Millisecond
+	fcVMStatePaused    = "Paused"
+	fcVMStateResumed   = "Resumed"
+	// The files of a Firecracker snapshot inside the image directory
+	FCSnapshotFilename = "fc.snapshot"
+	FCMemFilename      = "fc.mem"
+	// The file Firecracker appends its metrics to, in API mode
+	FCMetricsFilename = "fc.metrics"
+	// The actions of the Firecracker API
+	fcActionInstanceStart  = "InstanceStart"
+	fcActionSendCtrlAltDel = "SendCtrlAltDel"
+	fcActionFlushMetrics   = "FlushMetrics"
+)
+
+// firecrackerClient is a minimal client for the Firecracker API,
+// which is served over a Unix socket
+type firecrackerClient struct {
+	sockPath string
+	client   *http.Client
+}
+
+type firecrackerVMState struct {
+	State string `json:"state"`
+}
+
+type firecrackerSnapshotCreate struct {
+	SnapshotType string `json:"snapshot_type"`
+	SnapshotPath string `json:"snapshot_path"`
+	MemFilePath  string `json:"mem_file_path"`
+}
+
+type firecrackerMemBackend struct {
+	BackendType string `json:"backend_type"`
+	BackendPath string `json:"backend_path"`
+}
+
+type firecrackerSnapshotLoad struct {
+	SnapshotPath string                `json:"snapshot_path"`
+	MemBackend   firecrackerMemBackend `json:"mem_backend"`
+	ResumeVM     bool                  `json:"resume_vm"`
+}
+
+type firecrackerAction struct {
+	ActionType string `json:"action_type"`
+}
+
+type firecrackerBalloonUpdate struct {
+	AmountMiB uint64 `json:"amount_mib"`
+}
+
+type firecrackerAPIError struct {
+	FaultMessage string `json:"fault_message"`
+}
+
+// fcSockPath returns the path of the API socket of a Firecracker instance,
+// based on the container's state directory
+func fcSockPath(stateDir string) string {
+	return filepath.Join(stateDir, FCSockFilename)
+}
+
+// fcFilePath returns the path of a file that Execve created for the
+// Firecracker instance of a container, which is inside its jail, if any
+func fcFilePath(stateDir string, name string) string {
+	if isJailed(stateDir) {
+		return filepath.Join(stateDir, jailLinkName, "root", name)
+	}
+	return filepath.Join(stateDir, name)
+}
+
+// newFirecrackerClient returns a client for the API socket of the Firecracker
+// instance of a container. If Firecracker was not started with an API socket,
+// it returns ErrNotSupported.
+func newFirecrackerClient(stateDir string) (*firecrackerClient, error) {
+	sockPath := fcSockPath(stateDir)
+	_, err := os.Stat(sockPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotSupported
+		}
+		return nil, err
+	}
+	return &firecrackerClient{
+		sockPath: sockPath,
+		client: &http.Client{
+			Timeout: fcAPITimeout,
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, "unix", sockPath)
+				},
+			},
+		},
+	}, nil
+}
+
+// waitFirecrackerClient waits until the Firecracker instance of a container
+// creates its API socket and returns a client for it
+func waitFirecrackerClient(stateDir string, timeout time.Duration) (*firecrackerClient, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		client, err := newFirecrackerClient(stateDir)
+		if !errors.Is(err, ErrNotSupported) || time.Now().After(deadline) {
+			return client, err
+		}
+		time.Sleep(fcSockPollInterval)
+	}
+}
+
+// request sends a request to the Firecracker API. If out is not nil,
+// the response body is decoded into it.
+func (c *firecrackerClient) request(method string, path string, in interface{}, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	// The host part is ignored, since we always dial the Unix socket
+	req, err := http.NewRequest(method, "http://localhost"+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("firecracker API request %s %s failed: %w", method, path, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		var apiErr firecrackerAPIError
+		_ = json.NewDecoder(resp.Body).Decode(&apiErr)
+		return fmt.Errorf("firecracker API request %s %s failed with status %d: %s",
+			method, path, resp.StatusCode, apiErr.FaultMessage)
+	}
+	if out != nil {
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+	return nil
+}
+
+// setVMState changes the state of the microVM (Paused or Resumed)
+func (c *firecrackerClient) setVMState(state string) error {
+	return c.request(http.MethodPatch, "/vm", firecrackerVMState{State: state}, nil)
+}
+
+// createSnapshot creates a full snapshot of the paused microVM
+func (c *firecrackerClient) createSnapshot(snapshotPath string, memPath string) error {
+	return c.request(http.MethodPut, "/snapshot/create", firecrackerSnapshotCreate{
+		SnapshotType: "Full",
+		SnapshotPath: snapshotPath,
+		MemFilePath:  memPath,
+	}, nil)
+}
+
+// loadSnapshot loads a snapshot into a microVM that has not been booted yet
+// and resumes it
+func (c *firecrackerClient) loadSnapshot(snapshotPath string, memPath string) error {
+	return c.request(http.MethodPut, "/snapshot/load", firecrackerSnapshotLoad{
+		SnapshotPath: snapshotPath,
+		MemBackend: firecrackerMemBackend{
+			BackendType: "File",
+			BackendPath: memPath,
+		},
+		ResumeVM: true,
+	}, nil)
+}
+
+// action triggers an action of the Firecracker API
+func (c *firecrackerClient) action(actionType string) error {
+	return c.request(http.MethodPut, "/actions", firecrackerAction{ActionType: actionType}, nil)
+}
+
+// boot configures a microVM that has not been booted yet and starts it
+func (c *firecrackerClient) boot(config *FirecrackerConfig) error {
+	err := c.request(http.MethodPut, "/boot-source", config.Source, nil)
+	if err != nil {
+		return err
+	}
+	err = c.request(http.MethodPut, "/machine-config", config.Machine, nil)
+	if err != nil {
+		return err
+	}
+	for _, drive := range config.Drives {
+		err = c.request(http.MethodPut, "/drives/"+drive.DriveID, drive, nil)
+		if err != nil {
+			return err
+		}
+	}
+	for _, netIf := range config.NetIfs {
+		err = c.request(http.MethodPut, "/network-interfaces/"+netIf.IfaceID, netIf, nil)
+		if err != nil {
+			return err
+		}
+	}
+	if config.Metrics != nil {
+		err = c.request(http.MethodPut, "/metrics", config.Metrics, nil)
+		if err != nil {
+			return err
+		}
+	}
+	if config.Balloon != nil {
+		err = c.request(http.MethodPut, "/balloon", config.Balloon, nil)
+		if err != nil {
+			return err
+		}
+	}
+	return c.action(fcActionInstanceStart)
+}
+
+// setBalloon changes the target size of the balloon device
+func (c *firecrackerClient) setBalloon(amountMiB uint64) error {
+	return c.request(http.MethodPatch, "/balloon", firecrackerBalloonUpdate{AmountMiB: amountMiB}, nil)
+}
+
+// pid returns the PID of the Firecracker process that serves the API socket
+func (c *firecrackerClient) pid() (int, error) {
+	conn, err := net.DialTimeout("unix", c.sockPath, fcAPITimeout)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	raw, err := conn.(*net.UnixConn).SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	var cred *unix.Ucred
+	var credErr error
+	err = raw.Control(func(fd uintptr) {
+		cred, credErr = unix.GetsockoptUcred(int(fd), unix.SOL_SOCKET, unix.SO_PEERCRED)
+	})
+	if err != nil {
+		return 0, err
+	}
+	if credErr != nil {
+		return 0, credErr
+	}
+	return int(cred.Pid), nil
+}
@@ -0,0 +1,155 @@
+// Copyright (c) 2023-2024, Nubificus LTD
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hypervisors
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeFirecracker serves the Firecracker API on the socket of the state
+// directory and records the requests it receives
+type fakeFirecracker struct {
+	mu       sync.Mutex
+	requests []string
+	bodies   map[string]string
+}
+
+func newFakeFirecracker(t *testing.T, stateDir string) *fakeFirecracker {
+	t.Helper()
+	fake := &fakeFirecracker{bodies: make(map[string]string)}
+	listener, err := net.Listen("unix", fcSockPath(stateDir))
+	assert.NoError(t, err)
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { //nolint: gosec
+		body, _ := io.ReadAll(r.Body)
+		request := r.Method + " " + r.URL.Path
+		fake.mu.Lock()
+		fake.requests = append(fake.requests, request)
+		fake.bodies[request] = string(body)
+		fake.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	})}
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	t.Cleanup(func() {
+		_ = server.Close()
+	})
+	return fake
+}
+
+func (f *fakeFirecracker) recorded() ([]string, map[string]string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.requests, f.bodies
+}
+
+// saveAPIConfig saves the configuration of a microVM booted through the API
+// in the state directory, as Execve does
+func saveAPIConfig(t *testing.T, stateDir string) {
+	t.Helper()
+	args := goldenExecArgs(false)
+	args.StateDir = stateDir
+	args.APIBoot = true
+	data, err := json.Marshal((&Firecracker{}).config(args))
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(filepath.Join(stateDir, FCJsonFilename), data, 0o600))
+}
+
+func TestFirecrackerBoot(t *testing.T) {
+	stateDir := t.TempDir()
+	saveAPIConfig(t, stateDir)
+	fake := newFakeFirecracker(t, stateDir)
+
+	err := (&Firecracker{}).Boot(stateDir)
+	assert.NoError(t, err)
+	requests, bodies := fake.recorded()
+	assert.Equal(t, []string{
+		"PUT /boot-source",
+		"PUT /machine-config",
+		"PUT /drives/rootfs",
+		"PUT /drives/vol0",
+		"PUT /drives/vol1",
+		"PUT /network-interfaces/net1",
+		"PUT /metrics",
+		"PUT /balloon",
+		"PUT /actions",
+	}, requests)
+	assert.JSONEq(t, `{"action_type":"InstanceStart"}`, bodies["PUT /actions"])
+	assert.JSONEq(t, `{"metrics_path":"`+filepath.Join(stateDir, FCMetricsFilename)+`"}`, bodies["PUT /metrics"])
+}
+
+func TestFirecrackerShutdown(t *testing.T) {
+	stateDir := t.TempDir()
+	fake := newFakeFirecracker(t, stateDir)
+
+	err := (&Firecracker{}).Shutdown(stateDir)
+	assert.NoError(t, err)
+	_, bodies := fake.recorded()
+	assert.JSONEq(t, `{"action_type":"SendCtrlAltDel"}`, bodies["PUT /actions"])
+
+	// Stop finds the process to kill through the API socket
+	client, err := newFirecrackerClient(stateDir)
+	assert.NoError(t, err)
+	pid, err := client.pid()
+	assert.NoError(t, err)
+	assert.Equal(t, os.Getpid(), pid)
+
+	err = (&Firecracker{}).Shutdown(t.TempDir())
+	assert.ErrorIs(t, err, ErrNotSupported, "Expected shutdown without an API socket to be unsupported")
+}
+
+func TestFirecrackerMetrics(t *testing.T) {
+	stateDir := t.TempDir()
+	fake := newFakeFirecracker(t, stateDir)
+	fc := &Firecracker{}
+
+	_, err := fc.Metrics(stateDir)
+	assert.ErrorIs(t, err, ErrNotSupported, "Expected metrics without a metrics file to be unsupported")
+
+	metrics := `{"utc_timestamp_ms":1}` + "\n" + `{"utc_timestamp_ms":2}` + "\n"
+	assert.NoError(t, os.WriteFile(filepath.Join(stateDir, FCMetricsFilename), []byte(metrics), 0o600))
+	data, err := fc.Metrics(stateDir)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"utc_timestamp_ms":2}`, string(data), "Expected the latest metrics")
+	_, bodies := fake.recorded()
+	assert.JSONEq(t, `{"action_type":"FlushMetrics"}`, bodies["PUT /actions"])
+}
+
+func TestFirecrackerSetMemory(t *testing.T) {
+	stateDir := t.TempDir()
+	saveAPIConfig(t, stateDir)
+	fake := newFakeFirecracker(t, stateDir)
+	fc := &Firecracker{}
+
+	// The golden microVM has 488 MiB of memory
+	err := fc.SetMemory(stateDir, 400*1024*1024)
+	assert.NoError(t, err)
+	_, bodies := fake.recorded()
+	assert.JSONEq(t, `{"amount_mib":88}`, bodies["PATCH /balloon"])
+
+	err = fc.SetMemory(stateDir, 1024*1024*1024)
+	assert.NoError(t, err)
+	_, bodies = fake.recorded()
+	assert.JSONEq(t, `{"amount_mib":0}`, bodies["PATCH /balloon"], "Expected the balloon to deflate")
+}
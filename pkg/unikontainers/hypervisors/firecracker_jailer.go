@@ -0,0 +1,414 @@
+// Copyright (c) 2023-2024, Nubificus LTD
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hypervisors
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/moby/sys/mount"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	JailerBinary string = "jailer"
+	// The link in the container's state directory to the jail of its
+	// Firecracker instance
+	jailLinkName = "jail"
+	// The names of the files inside the chroot of the jail
+	jailKernelName = "kernel"
+	jailInitrdName = "initrd"
+	jailRootfsName = "rootfs"
+	// The suffix of the files next to the chroot that record the original
+	// owner of the images of the host that are handed over to the jail
+	jailOwnerSuffix = ".owner"
+)
+
+// The jailer only accepts IDs of up to 64 alphanumeric characters and hyphens
+var jailerIDRegexp = regexp.MustCompile(`^[a-zA-Z0-9-]{1,64}$`)
+
+// JailerConfig holds the settings of the Firecracker jailer. The jailer runs
+// Firecracker in a chroot, inside a new mount namespace and a dedicated cgroup,
+// with the privileges dropped to the given user and group.
+type JailerConfig struct {
+	UID           int    // The user Firecracker runs as
+	GID           int    // The group Firecracker runs as
+	ChrootBaseDir string // The directory under which the jails are created
+	ParentCgroup  string // The cgroup under which the jailer creates the cgroup of Firecracker
+	CgroupVersion int    // The cgroup version of the host (1 or 2)
+}
+
+// jailDir returns the directory of the jail of a container. The jailer
+// chroots Firecracker into its root subdirectory.
+func (fc *Firecracker) jailDir(args ExecArgs) string {
+	return filepath.Join(args.Jailer.ChrootBaseDir, filepath.Base(fc.Path()), args.Container)
+}
+
+// jailedArgs returns the args with the paths of the files that are exposed
+// in the chroot of the jail, as Firecracker sees them after the chroot
+func jailedArgs(args ExecArgs) ExecArgs {
+	jailed := args
+	jailed.UnikernelPath = "/" + jailKernelName
+	if args.InitrdPath != "" {
+		jailed.InitrdPath = "/" + jailInitrdName
+	}
+	if args.BlockDevice != "" {
+		jailed.BlockDevice = "/" + jailRootfsName
+	}
+	jailed.Volumes = make([]Volume, len(args.Volumes))
+	for i, volume := range args.Volumes {
+		jailed.Volumes[i] = Volume{Path: fmt.Sprintf("/vol%d", i), ReadOnly: volume.ReadOnly}
+	}
+	// The API socket is created in the root of the chroot
+	jailed.StateDir = "/"
+	return jailed
+}
+
+// populateJail exposes the files of the microVM in the chroot and hands the
+// tap device over to the user of the jail
+func populateJail(args ExecArgs, chroot string) error {
+	err := os.MkdirAll(chroot, 0o755)
+	if err != nil {
+		return err
+	}
+	jailed := jailedArgs(args)
+	err = jailImage(args.UnikernelPath, filepath.Join(chroot, jailed.UnikernelPath), args.RootfsDir)
+	if err != nil {
+		return err
+	}
+	if args.InitrdPath != "" {
+		err = jailImage(args.InitrdPath, filepath.Join(chroot, jailed.InitrdPath), args.RootfsDir)
+		if err != nil {
+			return err
+		}
+	}
+	if args.BlockDevice != "" {
+		err = jailDrive(args.BlockDevice, filepath.Join(chroot, jailed.BlockDevice), args.RootfsDir, args.Jailer)
+		if err != nil {
+			return err
+		}
+	}
+	for i, volume := range args.Volumes {
+		err = jailDrive(volume.Path, filepath.Join(chroot, jailed.Volumes[i].Path), args.RootfsDir, args.Jailer)
+		if err != nil {
+			return err
+		}
+	}
+	if args.TapDevice != "" {
+		err = setTapOwner(args.TapDevice, args.Jailer.UID, args.Jailer.GID)
+		if err != nil {
+			return fmt.Errorf("failed to set the owner of %s: %w", args.TapDevice, err)
+		}
+	}
+	return nil
+}
+
+// openJailSource opens the file at path, without opening it for reading or
+// writing, in order to expose it in the jail. The paths in the rootfs come from
+// the image, so they are resolved inside the rootfs, where a symlink can not
+// lead to a file of the host. It returns the file descriptor and its stat.
+func openJailSource(path string, rootfsDir string) (int, *unix.Stat_t, error) {
+	var fd int
+	var err error
+	rel, relErr := filepath.Rel(rootfsDir, path)
+	if rootfsDir == "" || relErr != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+		fd, err = unix.Open(path, unix.O_PATH|unix.O_CLOEXEC, 0)
+	} else {
+		var rootFd int
+		rootFd, err = unix.Open(rootfsDir, unix.O_PATH|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+		if err != nil {
+			return -1, nil, fmt.Errorf("failed to open %s: %w", rootfsDir, err)
+		}
+		defer unix.Close(rootFd)
+		fd, err = unix.Openat2(rootFd, rel, &unix.OpenHow{
+			Flags:   unix.O_PATH | unix.O_CLOEXEC,
+			Resolve: unix.RESOLVE_IN_ROOT | unix.RESOLVE_NO_MAGICLINKS,
+		})
+	}
+	if err != nil {
+		return -1, nil, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	var stat unix.Stat_t
+	err = unix.Fstat(fd, &stat)
+	if err != nil {
+		unix.Close(fd)
+		return -1, nil, fmt.Errorf("failed to stat %s: %w", path, err)
+	}
+	return fd, &stat, nil
+}
+
+// jailFile exposes the open file as target in the chroot, through a hard
+// link or, if the chroot is on another filesystem, a bind mount. Both go
+// through the file descriptor, so that the path is not resolved again.
+func jailFile(fd int, target string) error {
+	source := fmt.Sprintf("/proc/self/fd/%d", fd)
+	err := unix.Linkat(unix.AT_FDCWD, source, unix.AT_FDCWD, target, unix.AT_SYMLINK_FOLLOW)
+	if err == nil || !(errors.Is(err, unix.EXDEV) || errors.Is(err, unix.EPERM)) {
+		return err
+	}
+	err = os.WriteFile(target, nil, 0o600)
+	if err != nil {
+		return err
+	}
+	return mount.Mount(source, target, "none", "bind")
+}
+
+// jailImage exposes the regular file at path, such as the unikernel, as
+// target in the chroot
+func jailImage(path string, target string, rootfsDir string) error {
+	fd, stat, err := openJailSource(path, rootfsDir)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+	if stat.Mode&unix.S_IFMT != unix.S_IFREG {
+		return fmt.Errorf("cannot add %s to the jail: not a regular file", path)
+	}
+	err = jailFile(fd, target)
+	if err != nil {
+		return fmt.Errorf("failed to add %s to the jail: %w", path, err)
+	}
+	return nil
+}
+
+// jailDrive exposes a block device or image as target in the chroot and
+// makes it accessible by the user of the jail. Block devices are recreated
+// in the chroot, since device nodes can not be linked across filesystems.
+// An image shares its inode with the target, so its original owner is
+// recorded outside of the chroot and restored by removeJail. Anything else
+// is rejected.
+func jailDrive(path string, target string, rootfsDir string, jailer *JailerConfig) error {
+	fd, stat, err := openJailSource(path, rootfsDir)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+	switch stat.Mode & unix.S_IFMT {
+	case unix.S_IFBLK:
+		err = unix.Mknod(target, unix.S_IFBLK|0o600, int(stat.Rdev))
+		if err != nil {
+			return fmt.Errorf("failed to add %s to the jail: %w", path, err)
+		}
+		return os.Chown(target, jailer.UID, jailer.GID)
+	case unix.S_IFREG:
+	default:
+		return fmt.Errorf("cannot add %s to the jail: not a regular file or a block device", path)
+	}
+	err = jailFile(fd, target)
+	if err != nil {
+		return fmt.Errorf("failed to add %s to the jail: %w", path, err)
+	}
+	if int(stat.Uid) == jailer.UID && int(stat.Gid) == jailer.GID {
+		return nil
+	}
+	owner := fmt.Sprintf("%d:%d", stat.Uid, stat.Gid)
+	err = os.WriteFile(ownerRecord(target), []byte(owner), 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to record the owner of %s: %w", path, err)
+	}
+	return unix.Fchownat(fd, "", jailer.UID, jailer.GID, unix.AT_EMPTY_PATH)
+}
+
+// ownerRecord returns the file that records the original owner of the given
+// file of the chroot. It is kept in the jail directory, out of the reach of
+// the jailed Firecracker.
+func ownerRecord(target string) string {
+	jailDir := filepath.Dir(filepath.Dir(target))
+	return filepath.Join(jailDir, filepath.Base(target)+jailOwnerSuffix)
+}
+
+// restoreOwners gives the images of the host that were handed over to the
+// user of the jail back to their original owners
+func restoreOwners(jailDir string) error {
+	records, err := filepath.Glob(filepath.Join(jailDir, "*"+jailOwnerSuffix))
+	if err != nil {
+		return err
+	}
+	for _, record := range records {
+		data, err := os.ReadFile(record)
+		if err != nil {
+			return err
+		}
+		var uid, gid int
+		_, err = fmt.Sscanf(string(data), "%d:%d", &uid, &gid)
+		if err != nil {
+			return fmt.Errorf("invalid owner record %s: %w", record, err)
+		}
+		name := strings.TrimSuffix(filepath.Base(record), jailOwnerSuffix)
+		err = os.Lchown(filepath.Join(jailDir, "root", name), uid, gid)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to restore the owner of %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// setTapOwner allows the given user and group to attach to the persistent tap
+// device, since Firecracker has no CAP_NET_ADMIN after the jailer drops its
+// privileges. The flags must match the ones of the tap device, which are
+// reset on attach.
+func setTapOwner(name string, uid int, gid int) error {
+	fd, err := unix.Open("/dev/net/tun", unix.O_RDWR|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+	ifr, err := unix.NewIfreq(name)
+	if err != nil {
+		return err
+	}
+	ifr.SetUint16(unix.IFF_TAP | unix.IFF_NO_PI | unix.IFF_ONE_QUEUE | unix.IFF_VNET_HDR)
+	err = unix.IoctlIfreq(fd, unix.TUNSETIFF, ifr)
+	if err != nil {
+		return err
+	}
+	err = unix.IoctlSetInt(fd, unix.TUNSETOWNER, uid)
+	if err != nil {
+		return err
+	}
+	return unix.IoctlSetInt(fd, unix.TUNSETGROUP, gid)
+}
+
+// isJailed reports whether the Firecracker instance of a container was
+// started through the jailer
+func isJailed(stateDir string) bool {
+	_, err := os.Lstat(filepath.Join(stateDir, jailLinkName))
+	return err == nil
+}
+
+// removeJail tears down the jail of the Firecracker instance of a container,
+// if any. The owners of the images are restored while they are still exposed
+// in the chroot. The bind mounts are detached, since the jailed Firecracker
+// might still be running in its own mount namespace.
+func removeJail(stateDir string) error {
+	link := filepath.Join(stateDir, jailLinkName)
+	jailDir, err := os.Readlink(link)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	err = restoreOwners(jailDir)
+	if err != nil {
+		return err
+	}
+	err = mount.RecursiveUnmount(jailDir)
+	if err != nil {
+		return fmt.Errorf("failed to unmount the jail %s: %w", jailDir, err)
+	}
+	err = os.RemoveAll(jailDir)
+	if err != nil {
+		return fmt.Errorf("failed to remove the jail %s: %w", jailDir, err)
+	}
+	return os.Remove(link)
+}
+
+// jailerCommand builds the command line of the jailer, which executes
+// Firecracker with the given command line in the jail. The jailer is not
+// asked for a new PID namespace, since it would then fork Firecracker and
+// exit, while urunc tracks the VMM by the PID of the process it executes.
+// That process is spawned in a new PID namespace instead.
+func (fc *Firecracker) jailerCommand(jailerPath string, args ExecArgs, vmm *argvBuilder) *argvBuilder {
+	jailer := args.Jailer
+	cmd := newArgv(jailerPath).
+		Flag("--id", args.Container).
+		Flag("--exec-file", fc.Path()).
+		Flag("--uid", strconv.Itoa(jailer.UID)).
+		Flag("--gid", strconv.Itoa(jailer.GID)).
+		Flag("--chroot-base-dir", jailer.ChrootBaseDir)
+	if jailer.ParentCgroup != "" {
+		cmd.Flag("--cgroup-version", strconv.Itoa(jailer.CgroupVersion)).
+			Flag("--parent-cgroup", jailer.ParentCgroup)
+	}
+	return cmd.Arg("--").Arg(vmm.Argv()[1:]...)
+}
+
+// execveJailed prepares the jail of the container and executes the jailer
+func (fc *Firecracker) execveJailed(args ExecArgs) error {
+	jailerPath, err := exec.LookPath(JailerBinary)
+	if err != nil {
+		return fmt.Errorf("%s: %w", JailerBinary, ErrVMMNotInstalled)
+	}
+	if !jailerIDRegexp.MatchString(args.Container) {
+		return fmt.Errorf("container ID %s is not a valid jailer ID", args.Container)
+	}
+	if args.SnapshotDir != "" {
+		return fmt.Errorf("cannot restore a snapshot in the jail: %w", ErrNotSupported)
+	}
+
+	// Link the jail to the state directory before populating it, so that
+	// it is torn down along with the container, even if this fails
+	jailDir := fc.jailDir(args)
+	chroot := filepath.Join(jailDir, "root")
+	err = removeJail(args.StateDir)
+	if err != nil {
+		return err
+	}
+	err = os.Symlink(jailDir, filepath.Join(args.StateDir, jailLinkName))
+	if err != nil {
+		return err
+	}
+	err = populateJail(args, chroot)
+	if err != nil {
+		return err
+	}
+
+	jailed := jailedArgs(args)
+	JSONConfigFile := filepath.Join(chroot, FCJsonFilename)
+	FCConfigJSON, _ := json.Marshal(fc.config(jailed))
+	if err := os.WriteFile(JSONConfigFile, FCConfigJSON, 0o600); err != nil {
+		return fmt.Errorf("failed to save Firecracker json config: %w", err)
+	}
+	if err := os.Chown(JSONConfigFile, args.Jailer.UID, args.Jailer.GID); err != nil {
+		return err
+	}
+	vmmLog.WithField("Json=", string(FCConfigJSON)).Info("Firecracker json config")
+
+	configFile := "/" + FCJsonFilename
+	if args.APIBoot {
+		// Firecracker only opens an existing metrics file
+		metricsFile := filepath.Join(chroot, FCMetricsFilename)
+		err = os.WriteFile(metricsFile, nil, 0o600)
+		if err != nil {
+			return fmt.Errorf("failed to create Firecracker metrics file: %w", err)
+		}
+		err = os.Chown(metricsFile, args.Jailer.UID, args.Jailer.GID)
+		if err != nil {
+			return err
+		}
+		// The microVM is configured and booted by Boot
+		configFile = ""
+	}
+
+	// Expose the API socket of the jail in the state directory
+	err = os.Symlink(filepath.Join(chroot, FCSockFilename), fcSockPath(args.StateDir))
+	if err != nil {
+		return err
+	}
+
+	cmd := fc.jailerCommand(jailerPath, args, fc.command(jailed, configFile))
+	vmmLog.WithField("jailer command", cmd.String()).Info("Ready to execve the Firecracker jailer")
+
+	return syscall.Exec(jailerPath, cmd.Argv(), args.Environment) //nolint: gosec
+}
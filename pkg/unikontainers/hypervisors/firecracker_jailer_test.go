@@ -0,0 +1,156 @@
+// Copyright (c) 2023-2024, Nubificus LTD
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hypervisors
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+)
+
+// testJail populates the jail of a container with a kernel and a block
+// image, as execveJailed does, and returns its ExecArgs. The jail belongs to
+// another user, unless the test can not change the owner of files.
+func testJail(t *testing.T, chrootBaseDir string) ExecArgs {
+	t.Helper()
+	rootfs := t.TempDir()
+	uid, gid := os.Getuid(), os.Getgid()
+	if uid == 0 {
+		uid, gid = 1234, 4321
+	}
+	args := ExecArgs{
+		Container:     "test",
+		RootfsDir:     rootfs,
+		UnikernelPath: filepath.Join(rootfs, "unikernel"),
+		BlockDevice:   filepath.Join(rootfs, "block.img"),
+		StateDir:      t.TempDir(),
+		Jailer: &JailerConfig{
+			UID:           uid,
+			GID:           gid,
+			ChrootBaseDir: chrootBaseDir,
+		},
+	}
+	assert.NoError(t, os.WriteFile(args.UnikernelPath, []byte("kernel"), 0o644))
+	assert.NoError(t, os.WriteFile(args.BlockDevice, []byte("block"), 0o644))
+
+	fc := &Firecracker{binaryPath: "/usr/bin/firecracker"}
+	assert.NoError(t, os.Symlink(fc.jailDir(args), filepath.Join(args.StateDir, jailLinkName)))
+	assert.NoError(t, populateJail(args, filepath.Join(fc.jailDir(args), "root")))
+	return args
+}
+
+// assertJail checks the contents of the jail, its teardown and that the
+// files it exposed survive the teardown with their original owner
+func assertJail(t *testing.T, args ExecArgs) {
+	t.Helper()
+	fc := &Firecracker{binaryPath: "/usr/bin/firecracker"}
+	chroot := filepath.Join(fc.jailDir(args), "root")
+	data, err := os.ReadFile(filepath.Join(chroot, jailKernelName))
+	assert.NoError(t, err)
+	assert.Equal(t, "kernel", string(data))
+	data, err = os.ReadFile(filepath.Join(chroot, jailRootfsName))
+	assert.NoError(t, err)
+	assert.Equal(t, "block", string(data))
+	assertOwner(t, filepath.Join(chroot, jailRootfsName), args.Jailer.UID, args.Jailer.GID)
+	assert.True(t, isJailed(args.StateDir))
+
+	// There is no Firecracker to stop, but the jail is torn down anyway
+	err = fc.Stop(args.StateDir)
+	assert.ErrorIs(t, err, ErrNotSupported)
+	_, err = os.Stat(fc.jailDir(args))
+	assert.True(t, os.IsNotExist(err), "Expected the jail to be removed")
+	assert.False(t, isJailed(args.StateDir))
+	_, err = os.Stat(args.UnikernelPath)
+	assert.NoError(t, err, "Expected the kernel to survive the teardown of the jail")
+	assertOwner(t, args.BlockDevice, os.Getuid(), os.Getgid())
+	assert.NoError(t, fc.Cleanup(args.StateDir), "Expected the cleanup of a removed jail to succeed")
+}
+
+func assertOwner(t *testing.T, path string, uid int, gid int) {
+	t.Helper()
+	var stat unix.Stat_t
+	assert.NoError(t, unix.Stat(path, &stat))
+	assert.Equal(t, uid, int(stat.Uid), "Unexpected owner of %s", path)
+	assert.Equal(t, gid, int(stat.Gid), "Unexpected group of %s", path)
+}
+
+func TestJail(t *testing.T) {
+	t.Run("jail with hard links", func(t *testing.T) {
+		args := testJail(t, t.TempDir())
+		assertJail(t, args)
+	})
+
+	t.Run("jail with bind mounts", func(t *testing.T) {
+		if os.Getuid() != 0 {
+			t.Skip("bind mounts require root")
+		}
+		// A jail on another filesystem can not hard link the files
+		chrootBaseDir := t.TempDir()
+		assert.NoError(t, unix.Mount("tmpfs", chrootBaseDir, "tmpfs", 0, ""))
+		defer func() {
+			_ = unix.Unmount(chrootBaseDir, unix.MNT_DETACH)
+		}()
+		args := testJail(t, chrootBaseDir)
+		assertJail(t, args)
+	})
+}
+
+func TestJailDrive(t *testing.T) {
+	jailer := &JailerConfig{UID: os.Getuid(), GID: os.Getgid()}
+	if jailer.UID == 0 {
+		jailer.UID, jailer.GID = 1234, 4321
+	}
+	newRootfs := func(t *testing.T) (string, string) {
+		rootfs := t.TempDir()
+		chroot := filepath.Join(t.TempDir(), "root")
+		assert.NoError(t, os.Mkdir(chroot, 0o755))
+		assert.NoError(t, os.WriteFile(filepath.Join(rootfs, "block.img"), []byte("block"), 0o644))
+		return rootfs, chroot
+	}
+
+	t.Run("symlink is resolved in the rootfs", func(t *testing.T) {
+		rootfs, chroot := newRootfs(t)
+		assert.NoError(t, os.Symlink("/block.img", filepath.Join(rootfs, "link.img")))
+		target := filepath.Join(chroot, jailRootfsName)
+		err := jailDrive(filepath.Join(rootfs, "link.img"), target, rootfs, jailer)
+		assert.NoError(t, err)
+		data, err := os.ReadFile(target)
+		assert.NoError(t, err)
+		assert.Equal(t, "block", string(data), "Expected the image of the rootfs")
+		assertOwner(t, target, jailer.UID, jailer.GID)
+		assert.NoError(t, restoreOwners(filepath.Dir(chroot)))
+		assertOwner(t, filepath.Join(rootfs, "block.img"), os.Getuid(), os.Getgid())
+	})
+
+	t.Run("symlink to a host file", func(t *testing.T) {
+		rootfs, chroot := newRootfs(t)
+		hostFile := filepath.Join(t.TempDir(), "secret")
+		assert.NoError(t, os.WriteFile(hostFile, []byte("secret"), 0o600))
+		assert.NoError(t, os.Symlink(hostFile, filepath.Join(rootfs, "link.img")))
+		err := jailDrive(filepath.Join(rootfs, "link.img"), filepath.Join(chroot, jailRootfsName), rootfs, jailer)
+		assert.Error(t, err, "Expected the host file not to be reachable from the rootfs")
+		assertOwner(t, hostFile, os.Getuid(), os.Getgid())
+	})
+
+	t.Run("directory", func(t *testing.T) {
+		rootfs, chroot := newRootfs(t)
+		assert.NoError(t, os.Mkdir(filepath.Join(rootfs, "dir.img"), 0o755))
+		err := jailDrive(filepath.Join(rootfs, "dir.img"), filepath.Join(chroot, jailRootfsName), rootfs, jailer)
+		assert.ErrorContains(t, err, "not a regular file or a block device")
+	})
+}
@@ -16,6 +16,7 @@ package hypervisors
 
 import (
 	"fmt"
+	"path/filepath"
 
 	hedge "github.com/nubificus/hedge_cli/hedge_api"
 )
@@ -32,14 +33,21 @@ func (h *Hedge) Ok() error {
 	return hedge.Status()
 }
 
-func (h *Hedge) Stop(t string) error {
-	return hedge.StopVM(t)
+// Stop stops the Hedge VM of the container. VMs are named after the ID of
+// the container, which is the base name of its state directory.
+func (h *Hedge) Stop(stateDir string) error {
+	return hedge.StopVM(filepath.Base(stateDir))
 }
 
 func (h *Hedge) Path() string {
 	return ""
 }
 
+// ExitStatus returns the code as is, since Hedge VMs are not backed by a process
+func (h *Hedge) ExitStatus(code int) (int, bool) {
+	return code, false
+}
+
 func (h *Hedge) Execve(_ ExecArgs) error {
 	return fmt.Errorf("hedge not implemented yet")
 }
@@ -16,7 +16,6 @@ package hypervisors
 
 import (
 	"os/exec"
-	"strings"
 	"syscall"
 
 	seccomp "github.com/elastic/go-seccomp-bpf"
@@ -117,10 +116,10 @@ func applySeccompFilter() error {
 	return nil
 }
 
-// Stop is an empty function to satisfy VMM interface compatibility requirements.
-// It does not perform any actions and always returns nil.
+// Stop returns ErrNotSupported, since hvt can only be stopped by signaling
+// its process.
 func (h *HVT) Stop(_ string) error {
-	return nil
+	return ErrNotSupported
 }
 
 // Path returns the path to the hvt binary.
@@ -128,6 +127,11 @@ func (h *HVT) Path() string {
 	return h.binaryPath
 }
 
+// ExitStatus returns the status the unikernel exited with, unless it aborted.
+func (h *HVT) ExitStatus(code int) (int, bool) {
+	return solo5ExitStatus(code)
+}
+
 // Ok checks if the hvt binary is available in the system's PATH.
 func (h *HVT) Ok() error {
 	if _, err := exec.LookPath(HvtBinary); err != nil {
@@ -136,19 +140,24 @@ func (h *HVT) Ok() error {
 	return nil
 }
 
+// command builds the hvt command line. The guest command line is passed as
+// a single argument after the unikernel, which the tender hands to the guest.
+func (h *HVT) command(args ExecArgs) *argvBuilder {
+	return newArgv(h.binaryPath).
+		Option("--mem", bytesToStringMB(args.MemSizeB)).
+		OptionNonEmpty("--net:tap", args.TapDevice).
+		OptionNonEmpty("--block:rootfs", args.BlockDevice).
+		Arg(args.UnikernelPath, args.Command)
+}
+
 func (h *HVT) Execve(args ExecArgs) error {
-	hvtMem := bytesToStringMB(args.MemSizeB)
-	cmdString := h.binaryPath + " --mem=" + hvtMem
-	cmdString = appendNonEmpty(cmdString, " --net:tap=", args.TapDevice)
-	cmdString = appendNonEmpty(cmdString, " --block:rootfs=", args.BlockDevice)
-	cmdString += " " + args.UnikernelPath + " " + args.Command
-	cmdArgs := strings.Split(cmdString, " ")
+	cmd := h.command(args)
 	if args.Seccomp {
 		err := applySeccompFilter()
 		if err != nil {
 			return err
 		}
 	}
-	vmmLog.WithField("hvt command", cmdString).Error("Ready to execve hvt")
-	return syscall.Exec(h.binaryPath, cmdArgs, args.Environment) //nolint: gosec
+	vmmLog.WithField("hvt command", cmd.String()).Error("Ready to execve hvt")
+	return syscall.Exec(h.binaryPath, cmd.Argv(), args.Environment) //nolint: gosec
 }
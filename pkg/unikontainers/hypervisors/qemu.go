@@ -23,6 +23,10 @@ import (
 const (
 	QemuVmm    VmmType = "qemu"
 	QemuBinary string  = "qemu-system-"
+
+	// The I/O port of the isa-debug-exit device. A value v written to it
+	// terminates QEMU with exit code (v << 1) | 1.
+	qemuDebugExitPort = "0xf4"
 )
 
 type Qemu struct {
@@ -30,8 +34,41 @@ type Qemu struct {
 	binary     string
 }
 
+// Stop returns ErrNotSupported, since Qemu is stopped by signaling its process.
 func (q *Qemu) Stop(_ string) error {
-	return nil
+	return ErrNotSupported
+}
+
+// Shutdown sends an ACPI power button event to the guest through QMP
+func (q *Qemu) Shutdown(stateDir string) error {
+	return qmpExecute(qmpSockPath(stateDir), "system_powerdown")
+}
+
+// Pause stops the execution of the guest's vCPUs through QMP
+func (q *Qemu) Pause(stateDir string) error {
+	return qmpExecute(qmpSockPath(stateDir), "stop")
+}
+
+// Resume continues the execution of the guest's vCPUs through QMP
+func (q *Qemu) Resume(stateDir string) error {
+	return qmpExecute(qmpSockPath(stateDir), "cont")
+}
+
+// ExitStatus translates the exit code of QEMU. A guest reports the status of
+// the application by writing it to the isa-debug-exit device, which QEMU turns
+// to the odd exit code (status << 1) | 1. An exit code of 0 means that the
+// guest powered off. QEMU runs with -no-reboot, so a guest that resets on a
+// triple fault terminates QEMU, but with 0 as well, since QEMU does not tell a
+// reset from a poweroff. Any other even exit code is an error of QEMU itself.
+func (q *Qemu) ExitStatus(code int) (int, bool) {
+	switch {
+	case code%2 == 1:
+		return code >> 1, false
+	case code != 0:
+		return ExitStatusGuestCrash, true
+	default:
+		return code, false
+	}
 }
 
 func (q *Qemu) Ok() error {
@@ -41,36 +78,44 @@ func (q *Qemu) Path() string {
 	return q.binaryPath
 }
 
-func (q *Qemu) Execve(args ExecArgs) error {
-	qemuMem := bytesToStringMB(args.MemSizeB)
-	cmdString := q.binaryPath + " -m " + qemuMem + "M"
-	cmdString += " -cpu host"            // Choose CPU
-	cmdString += " -enable-kvm"          // Enable KVM to use CPU virt extensions
-	cmdString += " -nographic -vga none" // Disable graphic output
+// command builds the QEMU command line
+func (q *Qemu) command(args ExecArgs) *argvBuilder {
+	cmd := newArgv(q.binaryPath).
+		Flag("-m", bytesToStringMB(args.MemSizeB)+"M").
+		Flag("-cpu", "host").              // Choose CPU
+		Arg("-enable-kvm").                // Enable KVM to use CPU virt extensions
+		Arg("-nographic", "-vga", "none"). // Disable graphic output
+		Arg("-no-reboot")                  // Exit instead of rebooting the guest
 
 	if args.Seccomp {
 		// Enable Seccomp in QEMU
-		cmdString += " --sandbox on"
-		// Allow or Deny Obsolete system calls
-		cmdString += ",obsolete=deny"
-		// Allow or Deny set*uid|gid system calls
-		cmdString += ",elevateprivileges=deny"
-		// Allow or Deny *fork and execve
-		cmdString += ",spawn=deny"
-		// Allow or Deny process affinity and schedular priority
-		cmdString += ",resourcecontrol=deny"
+		sandbox := []string{
+			"on",
+			"obsolete=deny",          // Allow or Deny Obsolete system calls
+			"elevateprivileges=deny", // Allow or Deny set*uid|gid system calls
+			"spawn=deny",             // Allow or Deny *fork and execve
+			"resourcecontrol=deny",   // Allow or Deny process affinity and schedular priority
+		}
+		cmd.Flag("--sandbox", strings.Join(sandbox, ","))
 	}
 
 	// TODO: Check if this check causes any performance drop
 	// or explore alternative implementations
 	if runtime.GOARCH == "arm64" {
-		machineType := " -M virt"
-		cmdString += machineType
+		cmd.Flag("-M", "virt")
+	} else {
+		// Let the guest report its exit status
+		cmd.Flag("-device", "isa-debug-exit,iobase="+qemuDebugExitPort+",iosize=0x04")
+	}
+
+	if args.StateDir != "" {
+		cmd.Flag("-qmp", "unix:"+qemuOptionValue(qmpSockPath(args.StateDir))+",server,nowait")
 	}
 
-	cmdString += " -kernel " + args.UnikernelPath
+	cmd.Flag("-kernel", args.UnikernelPath)
 	if args.TapDevice != "" {
-		cmdString += " -net nic,model=virtio -net tap,script=no,ifname=" + args.TapDevice
+		cmd.Flag("-net", "nic,model=virtio").
+			Flag("-net", "tap,script=no,ifname="+qemuOptionValue(args.TapDevice))
 	}
 	if args.BlockDevice != "" {
 		// TODO: For the time being, we only have support for initrd with
@@ -79,10 +124,13 @@ func (q *Qemu) Execve(args ExecArgs) error {
 		vmmLog.Warn("Block device is currently not supported in QEMU execution")
 	}
 	if args.InitrdPath != "" {
-		cmdString += " -initrd " + args.InitrdPath
+		cmd.Flag("-initrd", args.InitrdPath)
 	}
-	exArgs := strings.Split(cmdString, " ")
-	exArgs = append(exArgs, "-append", args.Command)
-	vmmLog.WithField("qemu command", exArgs).Info("Ready to execve qemu")
-	return syscall.Exec(q.Path(), exArgs, args.Environment) //nolint: gosec
+	return cmd.Flag("-append", args.Command)
+}
+
+func (q *Qemu) Execve(args ExecArgs) error {
+	cmd := q.command(args)
+	vmmLog.WithField("qemu command", cmd.String()).Info("Ready to execve qemu")
+	return syscall.Exec(q.Path(), cmd.Argv(), args.Environment) //nolint: gosec
 }
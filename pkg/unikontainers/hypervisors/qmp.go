@@ -0,0 +1,109 @@
+// Copyright (c) 2023-2024, Nubificus LTD
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hypervisors
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	QMPSockFilename = "qmp.sock"
+	qmpTimeout      = 5 * time.Second
+)
+
+type qmpCommand struct {
+	Execute string `json:"execute"`
+}
+
+type qmpResponse struct {
+	Return json.RawMessage `json:"return,omitempty"`
+	Error  *struct {
+		Class string `json:"class"`
+		Desc  string `json:"desc"`
+	} `json:"error,omitempty"`
+	Event string `json:"event,omitempty"`
+}
+
+// qmpSockPath returns the path of the QMP socket of a QEMU instance,
+// based on the container's state directory
+func qmpSockPath(stateDir string) string {
+	return filepath.Join(stateDir, QMPSockFilename)
+}
+
+// qmpExecute connects to the QMP socket, negotiates the capabilities and
+// executes the given command. If QEMU was started without a QMP socket,
+// it returns ErrNotSupported.
+func qmpExecute(sockPath string, command string) error {
+	_, err := os.Stat(sockPath)
+	if os.IsNotExist(err) {
+		return ErrNotSupported
+	}
+	conn, err := net.DialTimeout("unix", sockPath, qmpTimeout)
+	if err != nil {
+		return fmt.Errorf("failed to connect to QMP socket %s: %w", sockPath, err)
+	}
+	defer conn.Close()
+	err = conn.SetDeadline(time.Now().Add(qmpTimeout))
+	if err != nil {
+		return err
+	}
+
+	reader := bufio.NewReader(conn)
+	// QEMU greets us with its version and capabilities
+	_, err = reader.ReadBytes('\n')
+	if err != nil {
+		return fmt.Errorf("failed to read QMP greeting: %w", err)
+	}
+	for _, cmd := range []string{"qmp_capabilities", command} {
+		err = json.NewEncoder(conn).Encode(qmpCommand{Execute: cmd})
+		if err != nil {
+			return fmt.Errorf("failed to send QMP command %s: %w", cmd, err)
+		}
+		err = qmpAwaitReturn(reader, cmd)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// qmpAwaitReturn reads the QMP replies, skipping any asynchronous events,
+// until the return value of the executed command is found
+func qmpAwaitReturn(reader *bufio.Reader, command string) error {
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			return fmt.Errorf("failed to read QMP reply for %s: %w", command, err)
+		}
+		var resp qmpResponse
+		err = json.Unmarshal(line, &resp)
+		if err != nil {
+			return fmt.Errorf("failed to parse QMP reply for %s: %w", command, err)
+		}
+		if resp.Event != "" {
+			continue
+		}
+		if resp.Error != nil {
+			return fmt.Errorf("QMP command %s failed: %s", command, resp.Error.Desc)
+		}
+		return nil
+	}
+}
@@ -16,7 +16,6 @@ package hypervisors
 
 import (
 	"os/exec"
-	"strings"
 	"syscall"
 )
 
@@ -30,10 +29,10 @@ type SPT struct {
 	binary     string
 }
 
-// Stop is an empty function to satisfy VMM interface compatibility requirements.
-// It does not perform any actions and always returns nil.
+// Stop returns ErrNotSupported, since spt can only be stopped by signaling
+// its process.
 func (s *SPT) Stop(_ string) error {
-	return nil
+	return ErrNotSupported
 }
 
 // Path returns the path to the spt binary.
@@ -41,6 +40,11 @@ func (s *SPT) Path() string {
 	return s.binaryPath
 }
 
+// ExitStatus returns the status the unikernel exited with, unless it aborted.
+func (s *SPT) ExitStatus(code int) (int, bool) {
+	return solo5ExitStatus(code)
+}
+
 // Ok checks if the spt binary is available in the system's PATH.
 func (s *SPT) Ok() error {
 	if _, err := exec.LookPath(SptBinary); err != nil {
@@ -49,13 +53,18 @@ func (s *SPT) Ok() error {
 	return nil
 }
 
+// command builds the spt command line. The guest command line is passed as
+// a single argument after the unikernel, which the tender hands to the guest.
+func (s *SPT) command(args ExecArgs) *argvBuilder {
+	return newArgv(s.binaryPath).
+		Option("--mem", bytesToStringMB(args.MemSizeB)).
+		OptionNonEmpty("--net:tap", args.TapDevice).
+		OptionNonEmpty("--block:rootfs", args.BlockDevice).
+		Arg(args.UnikernelPath, args.Command)
+}
+
 func (s *SPT) Execve(args ExecArgs) error {
-	sptMem := bytesToStringMB(args.MemSizeB)
-	cmdString := s.binaryPath + " --mem=" + sptMem
-	cmdString = appendNonEmpty(cmdString, " --net:tap=", args.TapDevice)
-	cmdString = appendNonEmpty(cmdString, " --block:rootfs=", args.BlockDevice)
-	cmdString += " " + args.UnikernelPath + " " + args.Command
-	cmdArgs := strings.Split(cmdString, " ")
-	vmmLog.WithField("spt command", cmdString).Error("Ready to execve spt")
-	return syscall.Exec(s.binaryPath, cmdArgs, args.Environment) //nolint: gosec
+	cmd := s.command(args)
+	vmmLog.WithField("spt command", cmd.String()).Error("Ready to execve spt")
+	return syscall.Exec(s.binaryPath, cmd.Argv(), args.Environment) //nolint: gosec
 }
@@ -30,11 +30,16 @@ func cpuArch() string {
 	}
 }
 
-func appendNonEmpty(body, prefix, value string) string {
-	if value != "" {
-		return body + prefix + value
+// Solo5 tenders exit with the status passed by the unikernel to solo5_exit(),
+// while solo5_abort() exits with SOLO5_EXIT_ABORT
+const solo5ExitAbort = 255
+
+// solo5ExitStatus translates the exit code of a Solo5 tender
+func solo5ExitStatus(code int) (int, bool) {
+	if code == solo5ExitAbort {
+		return ExitStatusGuestCrash, true
 	}
-	return body
+	return code, false
 }
 
 func bytesToMiB(bytes uint64) uint64 {
@@ -15,6 +15,7 @@
 package hypervisors
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"os/exec"
@@ -24,40 +25,142 @@ import (
 
 const DefaultMemory uint64 = 256 // The default memory for every hypervisor: 256 MB
 
+// ExitStatusGuestCrash is the exit status reported for a guest that panicked,
+// aborted or triple faulted, instead of exiting on its own. It follows the
+// shell convention for a process killed by SIGABRT (128+6).
+const ExitStatusGuestCrash = 134
+
 // ExecArgs holds the data required by Execve to start the VMM
 // FIXME: add extra fields if required by additional VMM's
 type ExecArgs struct {
-	Container     string   // The container ID
-	UnikernelPath string   // The path of the unikernel inside rootfs
-	TapDevice     string   // The TAP device name
-	BlockDevice   string   // The block device path
-	InitrdPath    string   // The path to the initrd of the unikernel
-	Command       string   // The unikernel's command line
-	IPAddress     string   // The IP address of the TAP device
-	GuestMAC      string   // The MAC address of the guest network device
-	Seccomp       bool     // Enable or disable seccomp filters for the VMM
-	MemSizeB      uint64   // The size of the memory provided to the VM in bytes
-	Environment   []string // Environment
+	Container     string        // The container ID
+	UnikernelPath string        // The path of the unikernel inside rootfs
+	RootfsDir     string        // The rootfs of the container, which holds the files of the image
+	TapDevice     string        // The TAP device name
+	BlockDevice   string        // The block device path
+	BlockDeviceRO bool          // Attach the block device read-only
+	RootDevice    bool          // The block device holds the container's rootfs
+	Volumes       []Volume      // Additional block devices for the guest
+	InitrdPath    string        // The path to the initrd of the unikernel
+	Command       string        // The unikernel's command line
+	IPAddress     string        // The IP address of the TAP device
+	GuestMAC      string        // The MAC address of the guest network device
+	Seccomp       bool          // Enable or disable seccomp filters for the VMM
+	MemSizeB      uint64        // The size of the memory provided to the VM in bytes
+	Environment   []string      // Environment
+	StateDir      string        // The container's state directory, used for VMM sockets
+	SnapshotDir   string        // The directory of a snapshot to restore the VM from, instead of booting
+	Jailer        *JailerConfig // Run the VMM through the jailer, if supported
+	APIBoot       bool          // Configure and boot the VM through the API of the VMM, after Execve
+}
+
+// Volume is an additional block device attached to the guest
+type Volume struct {
+	Path     string // The path of the block device (or image) on the host
+	ReadOnly bool   // Attach the block device read-only
 }
 
 type VmmType string
 
 var ErrVMMNotInstalled = errors.New("vmm not found")
+var ErrNotSupported = errors.New("operation not supported by the vmm")
 var vmmLog = logrus.WithField("subsystem", "hypervisors")
 
 type VMM interface {
 	Execve(args ExecArgs) error
-	Stop(t string) error
+	// Stop terminates the VMM of the container with the given state
+	// directory. It returns ErrNotSupported if the VMM can only be
+	// stopped by signaling its process.
+	Stop(stateDir string) error
 	Path() string
 	Ok() error
+	// ExitStatus translates the exit code of the VMM process to the exit
+	// status of the application in the guest. If the guest (or the VMM
+	// itself) crashed, it returns ExitStatusGuestCrash and true.
+	ExitStatus(code int) (int, bool)
+}
+
+// Shutdowner is implemented by the VMMs that can request from the guest to
+// shut down gracefully (e.g. through an ACPI power button event), instead of
+// terminating the VMM process.
+type Shutdowner interface {
+	Shutdown(stateDir string) error
+}
+
+// Pauser is implemented by the VMMs that can pause and resume the execution
+// of the guest. It returns ErrNotSupported if the specific VMM instance can not
+// be paused (e.g. Firecracker without an API socket).
+type Pauser interface {
+	Pause(stateDir string) error
+	Resume(stateDir string) error
+}
+
+// Snapshotter is implemented by the VMMs that can save the state of the guest
+// (memory and devices) to a snapshot in imageDir and restore a guest from it.
+// Restore is called after a VMM started with ExecArgs.SnapshotDir has been
+// executed and loads the snapshot into it.
+type Snapshotter interface {
+	Snapshot(stateDir string, imageDir string) error
+	Restore(stateDir string, imageDir string) error
+}
+
+// APIBooter is implemented by the VMMs that can configure and boot the guest
+// through their API. Boot is called after a VMM started with ExecArgs.APIBoot
+// has been executed.
+type APIBooter interface {
+	Boot(stateDir string) error
+}
+
+// MetricsReporter is implemented by the VMMs that report metrics of their
+// own, such as the activity of the guest's devices. It returns ErrNotSupported
+// if the specific VMM instance does not report metrics.
+type MetricsReporter interface {
+	Metrics(stateDir string) (json.RawMessage, error)
+}
+
+// Ballooner is implemented by the VMMs with a memory balloon device, which
+// adjusts the memory available to a running guest. SetMemory inflates or
+// deflates the balloon, so that the guest is left with sizeB bytes.
+type Ballooner interface {
+	SetMemory(stateDir string, sizeB uint64) error
 }
 
+// Cleaner is implemented by the VMMs that leave resources on the host for
+// each instance, such as the jail of Firecracker. Cleanup is called when the
+// container is deleted.
+type Cleaner interface {
+	Cleanup(stateDir string) error
+}
+
+// supportedVMMs holds all the VMM types that NewVMM knows how to create.
+// Always keep it in sync with the switch statement in newVMM.
+var supportedVMMs = []VmmType{SptVmm, HvtVmm, QemuVmm, FirecrackerVmm, CloudHypervisorVmm, HedgeVmm}
+
 func NewVMM(vmmType VmmType) (vmm VMM, err error) {
-	defer func() {
-		if err != nil {
-			vmmLog.Error(err.Error())
+	vmm, err = newVMM(vmmType)
+	if err != nil {
+		vmmLog.Error(err.Error())
+	}
+	return vmm, err
+}
+
+// SupportedVMMs returns the VMM types that urunc knows how to use
+func SupportedVMMs() []VmmType {
+	return supportedVMMs
+}
+
+// AvailableVMMs returns the supported VMM types that are installed on the host
+func AvailableVMMs() []VmmType {
+	var available []VmmType
+	for _, vmmType := range supportedVMMs {
+		if _, err := newVMM(vmmType); err == nil {
+			available = append(available, vmmType)
 		}
-	}()
+	}
+	return available
+}
+
+func newVMM(vmmType VmmType) (VMM, error) {
 	switch vmmType {
 	case SptVmm:
 		vmmPath, err := exec.LookPath(SptBinary)
@@ -83,6 +186,12 @@ func NewVMM(vmmType VmmType) (vmm VMM, err error) {
 			return nil, ErrVMMNotInstalled
 		}
 		return &Firecracker{binary: FirecrackerBinary, binaryPath: vmmPath}, nil
+	case CloudHypervisorVmm:
+		vmmPath, err := exec.LookPath(CloudHypervisorBinary)
+		if err != nil {
+			return nil, ErrVMMNotInstalled
+		}
+		return &CloudHypervisor{binary: CloudHypervisorBinary, binaryPath: vmmPath}, nil
 	case HedgeVmm:
 		hedge := Hedge{}
 		err := hedge.Ok()
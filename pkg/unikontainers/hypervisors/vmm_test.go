@@ -0,0 +1,54 @@
+// Copyright (c) 2023-2024, Nubificus LTD
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hypervisors
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExitStatus(t *testing.T) {
+	tests := []struct {
+		name    string
+		vmm     VMM
+		code    int
+		status  int
+		crashed bool
+	}{
+		{name: "hvt exit", vmm: &HVT{}, code: 3, status: 3},
+		{name: "hvt abort", vmm: &HVT{}, code: 255, status: ExitStatusGuestCrash, crashed: true},
+		{name: "spt exit", vmm: &SPT{}, code: 0, status: 0},
+		{name: "spt abort", vmm: &SPT{}, code: 255, status: ExitStatusGuestCrash, crashed: true},
+		{name: "qemu poweroff", vmm: &Qemu{}, code: 0, status: 0},
+		{name: "qemu debug exit 0", vmm: &Qemu{}, code: 1, status: 0},
+		{name: "qemu debug exit 1", vmm: &Qemu{}, code: 3, status: 1},
+		{name: "qemu debug exit", vmm: &Qemu{}, code: 7, status: 3},
+		{name: "qemu error", vmm: &Qemu{}, code: 2, status: ExitStatusGuestCrash, crashed: true},
+		{name: "firecracker shutdown", vmm: &Firecracker{}, code: 0, status: 0},
+		{name: "firecracker bad configuration", vmm: &Firecracker{}, code: 152, status: 152},
+		{name: "firecracker seccomp", vmm: &Firecracker{}, code: 148, status: ExitStatusGuestCrash, crashed: true},
+		{name: "cloud-hypervisor shutdown", vmm: &CloudHypervisor{}, code: 0, status: 0},
+		{name: "cloud-hypervisor panic", vmm: &CloudHypervisor{}, code: 101, status: ExitStatusGuestCrash, crashed: true},
+		{name: "firecracker segfault", vmm: &Firecracker{}, code: 150, status: ExitStatusGuestCrash, crashed: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			status, crashed := tc.vmm.ExitStatus(tc.code)
+			assert.Equal(t, tc.status, status)
+			assert.Equal(t, tc.crashed, crashed)
+		})
+	}
+}
@@ -15,8 +15,11 @@
 package unikontainers
 
 import (
+	"encoding/binary"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/fs"
 	"net"
 	"os"
@@ -26,18 +29,118 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
-type IPCMessage string
+// IPCMessageType is the type of a message exchanged between the urunc
+// processes of a container
+type IPCMessageType string
 
 const (
-	initSock                 = "init.sock"
-	uruncSock                = "urunc.sock"
-	ReexecStarted IPCMessage = "BOOTED"
-	AckReexec     IPCMessage = "ACK"
-	StartExecve   IPCMessage = "START"
-	maxRetries               = 50
-	waitTime                 = 5 * time.Millisecond
+	initSock                     = "init.sock"
+	uruncSock                    = "urunc.sock"
+	ReexecStarted IPCMessageType = "reexecStarted"
+	AckReexec     IPCMessageType = "ackReexec"
+	StartExecve   IPCMessageType = "startExecve"
+	ExecveFailed  IPCMessageType = "execveFailed"
+	maxRetries                   = 50
+	waitTime                     = 5 * time.Millisecond
+	// DefaultIPCTimeout is the default time to wait for a message from
+	// another urunc process of the container
+	DefaultIPCTimeout = 30 * time.Second
+	// How often to check if the peer process is still alive, while waiting
+	peerPollInterval = 50 * time.Millisecond
+	// The size of the header of each frame, holding the length of the payload
+	ipcHeaderSize = 4
+	// Messages are small, so anything bigger is a corrupted frame
+	maxIPCMessageSize = 64 * 1024
 )
 
+var (
+	// ErrIPCTimeout is returned when no message arrives before the deadline
+	ErrIPCTimeout = errors.New("timed out waiting for IPC message")
+	// ErrPeerExited is returned when the process that is expected to send
+	// a message exits before sending it
+	ErrPeerExited = errors.New("peer process exited")
+)
+
+// AwaitOptions bounds the time to wait for a message
+type AwaitOptions struct {
+	// Timeout is the maximum time to wait for the message. Zero means no timeout.
+	Timeout time.Duration
+	// PeerPid is the process that is expected to send the message. If it is
+	// set, the wait is aborted as soon as the process exits.
+	PeerPid int
+}
+
+// deadline returns the absolute deadline of the wait, or the zero time
+// if there is no timeout
+func (o AwaitOptions) deadline() time.Time {
+	if o.Timeout <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(o.Timeout)
+}
+
+// IPCMessage is a message exchanged between the urunc processes of a
+// container. Each message is sent as a frame of a 4-byte big endian length,
+// followed by the JSON encoded message.
+type IPCMessage struct {
+	Type        IPCMessageType `json:"type"`
+	ContainerID string         `json:"containerID"`
+	Pid         int            `json:"pid,omitempty"` // The PID of the sender, if relevant
+	Error       string         `json:"error,omitempty"`
+}
+
+// newIPCMessage creates a message of the given type. If err is not nil,
+// the message reports the error to the receiver.
+func newIPCMessage(msgType IPCMessageType, containerID string, err error) IPCMessage {
+	msg := IPCMessage{Type: msgType, ContainerID: containerID}
+	if err != nil {
+		msg.Error = err.Error()
+	}
+	return msg
+}
+
+// Err returns the error carried by the message, if any
+func (m IPCMessage) Err() error {
+	if m.Error == "" {
+		return nil
+	}
+	return fmt.Errorf("container %s: %s", m.ContainerID, m.Error)
+}
+
+// writeIPCMessage writes a single framed message to conn
+func writeIPCMessage(conn net.Conn, message IPCMessage) error {
+	payload, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	frame := make([]byte, ipcHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(frame, uint32(len(payload)))
+	copy(frame[ipcHeaderSize:], payload)
+	_, err = conn.Write(frame)
+	return err
+}
+
+// readIPCMessage reads a single framed message from conn
+func readIPCMessage(conn net.Conn) (IPCMessage, error) {
+	var msg IPCMessage
+	header := make([]byte, ipcHeaderSize)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return msg, err
+	}
+	size := binary.BigEndian.Uint32(header)
+	if size > maxIPCMessageSize {
+		return msg, fmt.Errorf("message size %d exceeds the maximum of %d bytes", size, maxIPCMessageSize)
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(conn, payload); err != nil {
+		return msg, err
+	}
+	if err := json.Unmarshal(payload, &msg); err != nil {
+		return msg, fmt.Errorf("failed to decode message: %w", err)
+	}
+	return msg, nil
+}
+
 func getSockAddr(dir string, name string) string {
 	return filepath.Join(dir, name)
 }
@@ -82,45 +185,92 @@ func SendIPCMessage(socketAddress string, message IPCMessage) error {
 	}
 	defer conn.Close()
 
-	if _, err := conn.Write([]byte(message)); err != nil {
-		return fmt.Errorf("failed to send message \"%s\" to \"%s\": %w", message, socketAddress, err)
+	if err := writeIPCMessage(conn, message); err != nil {
+		return fmt.Errorf("failed to send message \"%s\" to \"%s\": %w", message.Type, socketAddress, err)
 	}
 	return nil
 }
 
 // sendIPCMessageWithRetry attempts to connect to socketAddress. if successful, sends the message and closes the connection
 func sendIPCMessageWithRetry(socketAddress string, message IPCMessage, mustBeValid bool) error {
+	conn, err := dialWithRetry(socketAddress, mustBeValid)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err = conn.Close()
+		if err != nil {
+			logrus.WithError(err).Error("failed to close connection")
+		}
+	}()
+	err = writeIPCMessage(conn, message)
+	if err != nil {
+		logrus.WithError(err).Errorf("failed to send message \"%s\" to \"%s\"", message.Type, socketAddress)
+	}
+	return err
+}
+
+// sendIPCRequestWithRetry sends the message like sendIPCMessageWithRetry and
+// waits for the receiver to either reply with an error or close the connection.
+// The connection is closed without a reply when the request has succeeded,
+// e.g. when the reexec process executes the VMM. If timeout is not zero, it
+// returns ErrIPCTimeout when the receiver neither replies nor closes the
+// connection in time.
+func sendIPCRequestWithRetry(socketAddress string, message IPCMessage, mustBeValid bool, timeout time.Duration) error {
+	conn, err := dialWithRetry(socketAddress, mustBeValid)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err = conn.Close()
+		if err != nil {
+			logrus.WithError(err).Error("failed to close connection")
+		}
+	}()
+	err = writeIPCMessage(conn, message)
+	if err != nil {
+		return fmt.Errorf("failed to send message \"%s\" to \"%s\": %w", message.Type, socketAddress, err)
+	}
+	err = conn.SetReadDeadline(AwaitOptions{Timeout: timeout}.deadline())
+	if err != nil {
+		return err
+	}
+	reply, err := readIPCMessage(conn)
+	if errors.Is(err, io.EOF) {
+		return nil
+	}
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return fmt.Errorf("no reply to \"%s\" after %s: %w", message.Type, timeout, ErrIPCTimeout)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read reply to \"%s\": %w", message.Type, err)
+	}
+	if err := reply.Err(); err != nil {
+		return err
+	}
+	return fmt.Errorf("received unexpected reply: %s", reply.Type)
+}
+
+// dialWithRetry attempts to connect to socketAddress, until the listener is ready
+func dialWithRetry(socketAddress string, mustBeValid bool) (*net.UnixConn, error) {
 	if mustBeValid {
 		err := ensureValidSockAddr(socketAddress)
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
-	var conn *net.UnixConn
-	var err error
 	retry := 0
 	for {
-		conn, err = net.DialUnix("unix", nil, &net.UnixAddr{Name: socketAddress, Net: "unix"})
+		conn, err := net.DialUnix("unix", nil, &net.UnixAddr{Name: socketAddress, Net: "unix"})
 		if err == nil {
-			break
+			return conn, nil
 		}
 		retry++
 		if retry >= maxRetries {
-			return fmt.Errorf("failed to connect to %s, exceeded max retries", socketAddress)
+			return nil, fmt.Errorf("failed to connect to %s, exceeded max retries", socketAddress)
 		}
 		time.Sleep(waitTime)
 	}
-	defer func() {
-		err = conn.Close()
-		if err != nil {
-			logrus.WithError(err).Error("failed to close connection")
-		}
-	}()
-	_, err = conn.Write([]byte(message))
-	if err != nil {
-		logrus.WithError(err).Errorf("failed to send message \"%s\" to \"%s\"", message, socketAddress)
-	}
-	return err
 }
 
 // createListener sets up a listener for new connection to socketAddress
@@ -136,26 +286,140 @@ func CreateListener(socketAddress string, mustBeValid bool) (*net.UnixListener,
 }
 
 // awaitMessage opens a new connection to socketAddress
-// and waits for a given message
-func AwaitMessage(listener *net.UnixListener, expectedMessage IPCMessage) error {
-	conn, err := listener.AcceptUnix()
+// and waits for a given message. If the message carries an
+// error, the error is returned. The wait is bounded by opts.
+func AwaitMessage(listener *net.UnixListener, expectedMessage IPCMessageType, opts AwaitOptions) (IPCMessage, error) {
+	conn, msg, err := awaitConn(listener, expectedMessage, opts)
 	if err != nil {
-		return err
+		return msg, err
 	}
-	defer func() {
-		err = conn.Close()
-		if err != nil {
-			logrus.WithError(err).Error("failed to close connection")
+	err = conn.Close()
+	if err != nil {
+		logrus.WithError(err).Error("failed to close connection")
+	}
+	return msg, nil
+}
+
+// AwaitRequest waits for a given message like AwaitMessage, but returns the
+// connection, so that the caller can reply with ReplyIPCError. The
+// caller is responsible to close the connection.
+func AwaitRequest(listener *net.UnixListener, expectedMessage IPCMessageType, opts AwaitOptions) (*net.UnixConn, error) {
+	conn, _, err := awaitConn(listener, expectedMessage, opts)
+	return conn, err
+}
+
+// awaitConn accepts a connection and reads the expected message from it
+func awaitConn(listener *net.UnixListener, expectedMessage IPCMessageType, opts AwaitOptions) (*net.UnixConn, IPCMessage, error) {
+	deadline := opts.deadline()
+	conn, err := acceptWithDeadline(listener, deadline, opts)
+	if err != nil {
+		return nil, IPCMessage{}, err
+	}
+	msg, err := readMessageWithDeadline(conn, deadline, opts)
+	if err == nil {
+		err = msg.Err()
+		if err == nil && msg.Type != expectedMessage {
+			err = fmt.Errorf("received unexpected message: %s", msg.Type)
 		}
-	}()
-	buf := make([]byte, len(expectedMessage))
-	n, err := conn.Read(buf)
+	}
+	if err != nil {
+		if cerr := conn.Close(); cerr != nil {
+			logrus.WithError(cerr).Error("failed to close connection")
+		}
+		return nil, msg, err
+	}
+	return conn, msg, nil
+}
+
+// acceptWithDeadline accepts a new connection on listener. It fails with
+// ErrIPCTimeout after the deadline, or with ErrPeerExited if opts.PeerPid
+// exits in the meantime.
+func acceptWithDeadline(listener *net.UnixListener, deadline time.Time, opts AwaitOptions) (*net.UnixConn, error) {
+	err := listener.SetDeadline(deadline)
 	if err != nil {
-		return fmt.Errorf("failed to read from socket: %w", err)
+		return nil, err
 	}
-	msg := string(buf[0:n])
-	if msg != string(expectedMessage) {
-		return fmt.Errorf("received unexpected message: %s", msg)
+	// Wake up Accept when the peer exits, by moving the deadline. A grace
+	// period lets a connection that the peer made before exiting through.
+	stop := monitorPeer(opts.PeerPid, func() {
+		_ = listener.SetDeadline(time.Now().Add(peerPollInterval))
+	})
+	conn, err := listener.AcceptUnix()
+	peerExited := stop()
+	if rerr := listener.SetDeadline(time.Time{}); rerr != nil {
+		logrus.WithError(rerr).Error("failed to reset listener deadline")
 	}
-	return nil
+	if err != nil {
+		return nil, awaitError(err, peerExited, opts)
+	}
+	return conn, nil
+}
+
+// readMessageWithDeadline reads a message from conn, like acceptWithDeadline
+// accepts a connection
+func readMessageWithDeadline(conn *net.UnixConn, deadline time.Time, opts AwaitOptions) (IPCMessage, error) {
+	err := conn.SetReadDeadline(deadline)
+	if err != nil {
+		return IPCMessage{}, err
+	}
+	stop := monitorPeer(opts.PeerPid, func() {
+		_ = conn.SetReadDeadline(time.Now().Add(peerPollInterval))
+	})
+	msg, err := readIPCMessage(conn)
+	peerExited := stop()
+	if rerr := conn.SetReadDeadline(time.Time{}); rerr != nil {
+		logrus.WithError(rerr).Error("failed to reset connection deadline")
+	}
+	if err != nil {
+		return msg, awaitError(fmt.Errorf("failed to read from socket: %w", err), peerExited, opts)
+	}
+	return msg, nil
+}
+
+// awaitError translates the error of an interrupted wait
+func awaitError(err error, peerExited bool, opts AwaitOptions) error {
+	if peerExited {
+		return fmt.Errorf("process %d exited before sending a message: %w", opts.PeerPid, ErrPeerExited)
+	}
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return fmt.Errorf("no message after %s: %w", opts.Timeout, ErrIPCTimeout)
+	}
+	return err
+}
+
+// monitorPeer polls the given process and calls onExit once, if the process
+// exits. The returned function stops the monitoring and returns true if
+// onExit was called. If pid is not positive, nothing is monitored.
+func monitorPeer(pid int, onExit func()) func() bool {
+	if pid <= 0 {
+		return func() bool { return false }
+	}
+	done := make(chan struct{})
+	exited := make(chan bool, 1)
+	go func() {
+		ticker := time.NewTicker(peerPollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				exited <- false
+				return
+			case <-ticker.C:
+				if !processAlive(pid, "") {
+					onExit()
+					exited <- true
+					return
+				}
+			}
+		}
+	}()
+	return func() bool {
+		close(done)
+		return <-exited
+	}
+}
+
+// ReplyIPCError reports the failure of a request to its sender
+func ReplyIPCError(conn *net.UnixConn, containerID string, reqErr error) error {
+	return writeIPCMessage(conn, newIPCMessage(ExecveFailed, containerID, reqErr))
 }
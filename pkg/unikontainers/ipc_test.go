@@ -15,9 +15,12 @@
 package unikontainers
 
 import (
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"net"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"testing"
 	"time"
@@ -88,14 +91,13 @@ func readExpectedMsgFromSocket(socketAddr string, message IPCMessage, waitChan c
 	}
 	defer conn.Close()
 
-	buf := make([]byte, len(message))
-	n, err := conn.Read(buf)
+	msg, err := readIPCMessage(conn)
 	if err != nil {
 		return err
 	}
 
-	if string(message) != string(buf[:n]) {
-		return fmt.Errorf("Expected %s, but got %s", message, string(buf[:n]))
+	if msg != message {
+		return fmt.Errorf("Expected %v, but got %v", message, msg)
 	}
 	return nil
 }
@@ -128,14 +130,14 @@ func testSendIPCMessageHelper(t *testing.T, socketAddress string, message IPCMes
 
 func TestSendIPCMessage(t *testing.T) {
 	socketAddress := "/tmp/test.sock"
-	message := ReexecStarted
+	message := newIPCMessage(ReexecStarted, "test", nil)
 
 	testSendIPCMessageHelper(t, socketAddress, message, SendIPCMessage)
 }
 
 func TestSendIPCMessageWithRetry(t *testing.T) {
 	socketAddress := "/tmp/test_retry.sock"
-	message := ReexecStarted
+	message := newIPCMessage(ReexecStarted, "test", nil)
 
 	// Wrapping the sendIPCMessageWithRetry function to match the expected function signature.
 	sendWithRetry := func(addr string, msg IPCMessage) error {
@@ -176,12 +178,198 @@ func TestAwaitMessage(t *testing.T) {
 		}
 		defer conn.Close()
 
-		_, err = conn.Write([]byte(expectedMessage))
+		err = writeIPCMessage(conn, newIPCMessage(expectedMessage, "test", nil))
 		if err != nil {
 			t.Errorf("Failed to send message: %v", err)
 		}
 	}()
 
-	err = AwaitMessage(listener, expectedMessage)
+	_, err = AwaitMessage(listener, expectedMessage, AwaitOptions{})
 	assert.NoError(t, err, "Expected no error in awaiting message")
 }
+
+func TestAwaitMessageErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		message IPCMessage
+		errMsg  string
+	}{
+		{
+			name:    "unexpected type",
+			message: newIPCMessage(AckReexec, "test", nil),
+			errMsg:  "received unexpected message: ackReexec",
+		},
+		{
+			name:    "carried error",
+			message: newIPCMessage(ReexecStarted, "test", errors.New("vmm not found")),
+			errMsg:  "container test: vmm not found",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			socketAddress := filepath.Join(t.TempDir(), "await.sock")
+			listener, err := CreateListener(socketAddress, true)
+			if err != nil {
+				t.Fatalf("Failed to create listener: %v", err)
+			}
+			defer listener.Close()
+
+			go func() {
+				_ = sendIPCMessageWithRetry(socketAddress, tc.message, true)
+			}()
+
+			_, err = AwaitMessage(listener, ReexecStarted, AwaitOptions{})
+			assert.EqualError(t, err, tc.errMsg)
+		})
+	}
+}
+
+func TestReadIPCMessageTooLarge(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+
+	go func() {
+		defer client.Close()
+		header := make([]byte, ipcHeaderSize)
+		binary.BigEndian.PutUint32(header, maxIPCMessageSize+1)
+		_, _ = client.Write(header)
+	}()
+
+	_, err := readIPCMessage(server)
+	assert.Error(t, err, "Expected error for oversized frame")
+}
+
+func TestSendIPCRequestWithRetry(t *testing.T) {
+	tests := []struct {
+		name    string
+		reply   error
+		wantErr string
+	}{
+		{
+			name: "connection closed",
+		},
+		{
+			name:    "error reply",
+			reply:   errors.New("failed to setup network"),
+			wantErr: "container test: failed to setup network",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			socketAddress := filepath.Join(t.TempDir(), "request.sock")
+			listener, err := CreateListener(socketAddress, true)
+			if err != nil {
+				t.Fatalf("Failed to create listener: %v", err)
+			}
+			defer listener.Close()
+
+			go func() {
+				conn, err := AwaitRequest(listener, StartExecve, AwaitOptions{})
+				if err != nil {
+					t.Errorf("Failed to await request: %v", err)
+					return
+				}
+				defer conn.Close()
+				if tc.reply != nil {
+					_ = ReplyIPCError(conn, "test", tc.reply)
+				}
+			}()
+
+			err = sendIPCRequestWithRetry(socketAddress, newIPCMessage(StartExecve, "test", nil), true, 0)
+			if tc.wantErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestAwaitMessageTimeout(t *testing.T) {
+	socketAddress := filepath.Join(t.TempDir(), "timeout.sock")
+	listener, err := CreateListener(socketAddress, true)
+	if err != nil {
+		t.Fatalf("Failed to create listener: %v", err)
+	}
+	defer listener.Close()
+
+	_, err = AwaitMessage(listener, ReexecStarted, AwaitOptions{Timeout: 50 * time.Millisecond})
+	assert.ErrorIs(t, err, ErrIPCTimeout)
+
+	// A peer that connects but never sends a message also times out
+	go func() {
+		conn, err := dialWithRetry(socketAddress, true)
+		if err != nil {
+			return
+		}
+		time.Sleep(time.Second)
+		conn.Close()
+	}()
+	_, err = AwaitMessage(listener, ReexecStarted, AwaitOptions{Timeout: 100 * time.Millisecond})
+	assert.ErrorIs(t, err, ErrIPCTimeout)
+}
+
+func TestAwaitMessagePeerExited(t *testing.T) {
+	socketAddress := filepath.Join(t.TempDir(), "peer.sock")
+	listener, err := CreateListener(socketAddress, true)
+	if err != nil {
+		t.Fatalf("Failed to create listener: %v", err)
+	}
+	defer listener.Close()
+
+	cmd := exec.Command("sleep", "0.1")
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("Failed to start peer process: %v", err)
+	}
+	defer func() { _ = cmd.Wait() }()
+
+	start := time.Now()
+	_, err = AwaitMessage(listener, ReexecStarted, AwaitOptions{Timeout: 10 * time.Second, PeerPid: cmd.Process.Pid})
+	assert.ErrorIs(t, err, ErrPeerExited)
+	assert.Less(t, time.Since(start), 5*time.Second, "Expected the wait to stop when the peer exits")
+}
+
+func TestAwaitMessageFromExitedPeer(t *testing.T) {
+	socketAddress := filepath.Join(t.TempDir(), "exited.sock")
+	listener, err := CreateListener(socketAddress, true)
+	if err != nil {
+		t.Fatalf("Failed to create listener: %v", err)
+	}
+	defer listener.Close()
+
+	// The peer reports an error and exits, before the message is accepted
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("Failed to run peer process: %v", err)
+	}
+	err = sendIPCMessageWithRetry(socketAddress, newIPCMessage(ReexecStarted, "test", errors.New("boom")), true)
+	if err != nil {
+		t.Fatalf("Failed to send message: %v", err)
+	}
+
+	_, err = AwaitMessage(listener, ReexecStarted, AwaitOptions{PeerPid: cmd.Process.Pid})
+	assert.EqualError(t, err, "container test: boom")
+}
+
+func TestSendIPCRequestTimeout(t *testing.T) {
+	socketAddress := filepath.Join(t.TempDir(), "request_timeout.sock")
+	listener, err := CreateListener(socketAddress, true)
+	if err != nil {
+		t.Fatalf("Failed to create listener: %v", err)
+	}
+	defer listener.Close()
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		conn, err := AwaitRequest(listener, StartExecve, AwaitOptions{})
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		<-done
+	}()
+
+	err = sendIPCRequestWithRetry(socketAddress, newIPCMessage(StartExecve, "test", nil), true, 50*time.Millisecond)
+	assert.ErrorIs(t, err, ErrIPCTimeout)
+}
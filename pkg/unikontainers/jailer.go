@@ -0,0 +1,116 @@
+// Copyright (c) 2023-2024, Nubificus LTD
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package unikontainers
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/nubificus/urunc/pkg/unikontainers/hypervisors"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"golang.org/x/sys/unix"
+)
+
+// The jailer mode of Firecracker is enabled for all containers of the host
+// through the environment of urunc, or per container through the annotation.
+// The annotation can only enable the jailer, so that a container can not
+// escape the jail that the host requires. The rest of its settings are host wide.
+const (
+	annotJailer             = "com.urunc.jailer"
+	jailerEnv               = "URUNC_JAILER"
+	jailerUIDEnv            = "URUNC_JAILER_UID"
+	jailerGIDEnv            = "URUNC_JAILER_GID"
+	jailerChrootBaseDirEnv  = "URUNC_JAILER_CHROOT_BASE_DIR"
+	jailerParentCgroupEnv   = "URUNC_JAILER_PARENT_CGROUP"
+	defaultJailerID         = 65534 // nobody
+	defaultJailerChrootBase = "/srv/jailer"
+)
+
+// jailerConfig returns the jailer settings for the given spec, or nil if the
+// jailer is not enabled
+func jailerConfig(spec *specs.Spec) (*hypervisors.JailerConfig, error) {
+	hostEnabled, err := parseBoolOption(os.Getenv(jailerEnv), jailerEnv)
+	if err != nil {
+		return nil, err
+	}
+	enabled, err := parseBoolOption(spec.Annotations[annotJailer], annotJailer)
+	if err != nil {
+		return nil, err
+	}
+	if !hostEnabled && !enabled {
+		return nil, nil
+	}
+
+	uid, err := jailerID(jailerUIDEnv)
+	if err != nil {
+		return nil, err
+	}
+	gid, err := jailerID(jailerGIDEnv)
+	if err != nil {
+		return nil, err
+	}
+	chrootBaseDir := os.Getenv(jailerChrootBaseDirEnv)
+	if chrootBaseDir == "" {
+		chrootBaseDir = defaultJailerChrootBase
+	}
+	if !filepath.IsAbs(chrootBaseDir) {
+		return nil, fmt.Errorf("invalid %s %q: not an absolute path", jailerChrootBaseDirEnv, chrootBaseDir)
+	}
+	cgroupVersion := 1
+	if _, err := os.Stat(filepath.Join(cgroupRoot, "cgroup.controllers")); err == nil {
+		cgroupVersion = 2
+	}
+	return &hypervisors.JailerConfig{
+		UID:           uid,
+		GID:           gid,
+		ChrootBaseDir: chrootBaseDir,
+		ParentCgroup:  os.Getenv(jailerParentCgroupEnv),
+		CgroupVersion: cgroupVersion,
+	}, nil
+}
+
+// jailerID returns the user or group ID in the given environment variable
+func jailerID(env string) (int, error) {
+	value := os.Getenv(env)
+	if value == "" {
+		return defaultJailerID, nil
+	}
+	id, err := strconv.Atoi(value)
+	if err != nil || id < 0 {
+		return 0, fmt.Errorf("invalid %s %q", env, value)
+	}
+	return id, nil
+}
+
+// ReexecCloneFlags returns the namespaces that the reexec process is spawned
+// in. Besides its own network namespace, a jailed Firecracker gets a new PID
+// namespace. The jailer can create it too, but it then forks Firecracker and
+// exits, which would end the process that urunc tracks as the container's.
+func (u *Unikontainer) ReexecCloneFlags() (uintptr, error) {
+	flags := uintptr(unix.CLONE_NEWNET)
+	if hypervisors.VmmType(u.State.Annotations[annotHypervisor]) != hypervisors.FirecrackerVmm {
+		return flags, nil
+	}
+	jailer, err := jailerConfig(u.Spec)
+	if err != nil {
+		return 0, err
+	}
+	if jailer != nil {
+		flags |= unix.CLONE_NEWPID
+	}
+	return flags, nil
+}
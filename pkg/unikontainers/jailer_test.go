@@ -0,0 +1,90 @@
+// Copyright (c) 2023-2024, Nubificus LTD
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package unikontainers
+
+import (
+	"testing"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+)
+
+func TestJailerConfig(t *testing.T) {
+	tests := []struct {
+		name       string
+		env        string
+		annotation map[string]string
+		uid        string
+		enabled    bool
+		wantErr    bool
+	}{
+		{name: "disabled by default"},
+		{name: "enabled by host", env: "true", enabled: true},
+		{name: "not disabled by annotation", env: "true", annotation: map[string]string{annotJailer: "false"}, enabled: true},
+		{name: "enabled by annotation", annotation: map[string]string{annotJailer: "true"}, enabled: true},
+		{name: "invalid annotation", env: "true", annotation: map[string]string{annotJailer: "no way"}, wantErr: true},
+		{name: "invalid mode", env: "yes", wantErr: true},
+		{name: "invalid uid", env: "true", uid: "-1", wantErr: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv(jailerEnv, tc.env)
+			t.Setenv(jailerUIDEnv, tc.uid)
+			t.Setenv(jailerGIDEnv, "1000")
+			t.Setenv(jailerChrootBaseDirEnv, "")
+			t.Setenv(jailerParentCgroupEnv, "urunc")
+
+			config, err := jailerConfig(&specs.Spec{Annotations: tc.annotation})
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			if !tc.enabled {
+				assert.Nil(t, config)
+				return
+			}
+			if assert.NotNil(t, config) {
+				assert.Equal(t, defaultJailerID, config.UID)
+				assert.Equal(t, 1000, config.GID)
+				assert.Equal(t, defaultJailerChrootBase, config.ChrootBaseDir)
+				assert.Equal(t, "urunc", config.ParentCgroup)
+			}
+		})
+	}
+}
+
+func TestReexecCloneFlags(t *testing.T) {
+	t.Setenv(jailerEnv, "")
+	newContainer := func(vmm string, jailer string) *Unikontainer {
+		return &Unikontainer{
+			State: &specs.State{Annotations: map[string]string{annotHypervisor: vmm}},
+			Spec:  &specs.Spec{Annotations: map[string]string{annotJailer: jailer}},
+		}
+	}
+
+	flags, err := newContainer("firecracker", "true").ReexecCloneFlags()
+	assert.NoError(t, err)
+	assert.Equal(t, uintptr(unix.CLONE_NEWNET|unix.CLONE_NEWPID), flags, "Expected a new PID namespace for the jailer")
+	flags, err = newContainer("firecracker", "").ReexecCloneFlags()
+	assert.NoError(t, err)
+	assert.Equal(t, uintptr(unix.CLONE_NEWNET), flags)
+	flags, err = newContainer("qemu", "true").ReexecCloneFlags()
+	assert.NoError(t, err)
+	assert.Equal(t, uintptr(unix.CLONE_NEWNET), flags, "Expected the jailer to only apply to Firecracker")
+	_, err = newContainer("firecracker", "maybe").ReexecCloneFlags()
+	assert.Error(t, err)
+}
@@ -0,0 +1,174 @@
+// Copyright (c) 2023-2024, Nubificus LTD
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package unikontainers
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nubificus/urunc/pkg/unikontainers/hypervisors"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/sirupsen/logrus"
+)
+
+// Exit reasons of the VMM, when the exit code is not known, and of guests
+// that crashed
+const (
+	exitReasonExited  = "exited"
+	exitReasonKilled  = "killed"
+	exitReasonCrashed = "crashed"
+)
+
+// ErrInvalidTransition is returned when an operation is not allowed in the
+// current status of the container
+var ErrInvalidTransition = errors.New("invalid container state transition")
+
+// TransitionError describes an operation that was rejected, because of the
+// current status of the container. It wraps ErrInvalidTransition.
+type TransitionError struct {
+	ID     string
+	Op     string
+	Status specs.ContainerState
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("cannot %s container %s: container is %s", e.Op, e.ID, e.Status)
+}
+
+func (e *TransitionError) Unwrap() error {
+	return ErrInvalidTransition
+}
+
+// checkTransition returns a TransitionError if the current status of the
+// container is not one of the allowed statuses for the given operation
+func (u *Unikontainer) checkTransition(op string, allowed ...specs.ContainerState) error {
+	status := u.CurrentState().Status
+	for _, s := range allowed {
+		if status == s {
+			return nil
+		}
+	}
+	return &TransitionError{ID: u.State.ID, Op: op, Status: status}
+}
+
+// recordStartTime stores the start time of the container's process, so that
+// a reused PID is not mistaken for the VMM
+func (u *Unikontainer) recordStartTime() error {
+	startTime, err := getProcessStartTime(u.State.Pid)
+	if err != nil {
+		return fmt.Errorf("failed to get start time of process %d: %w", u.State.Pid, err)
+	}
+	u.runtime.StartTime = startTime
+	return nil
+}
+
+// ExitStatus translates the exit code of a VMM that exited on its own to the
+// exit status of the application in the guest, which is the one recorded and
+// reported for the container. Any other reason (e.g. the VMM was killed by a
+// signal) is returned as is.
+func (u *Unikontainer) ExitStatus(code int, reason string) (int, string) {
+	if reason != exitReasonExited {
+		return code, reason
+	}
+	vmmType := hypervisors.VmmType(u.State.Annotations[annotHypervisor])
+	vmm, err := hypervisors.NewVMM(vmmType)
+	if err != nil {
+		Log.WithError(err).Warn("failed to get vmm, keeping the exit code of the VMM")
+		return code, reason
+	}
+	status, crashed := vmm.ExitStatus(code)
+	if crashed {
+		Log.WithFields(logrus.Fields{
+			"id":   u.State.ID,
+			"code": code,
+		}).Warn("guest crashed")
+		return status, exitReasonCrashed
+	}
+	return status, reason
+}
+
+// SetExitStatus marks the container as stopped and stores the exit code
+// of the VMM, along with the reason it exited
+func (u *Unikontainer) SetExitStatus(code int, reason string) error {
+	unlock, err := u.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	now := time.Now()
+	u.runtime.ExitCode = &code
+	u.runtime.ExitReason = reason
+	u.runtime.ExitedAt = &now
+	u.State.Status = specs.StateStopped
+	return u.saveContainerState()
+}
+
+// SetMonitorPid stores the PID of the monitor, the process that waits
+// for the VMM
+func (u *Unikontainer) SetMonitorPid(pid int) error {
+	unlock, err := u.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	u.runtime.MonitorPid = pid
+	return u.saveContainerState()
+}
+
+// Reap is called by the monitor, once the VMM has exited. It records the
+// exit status, releases the network resources and executes the Poststop
+// hooks. A container that was never created is left to the create command.
+func (u *Unikontainer) Reap(code int, reason string) error {
+	unlock, err := u.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if u.State.Status == specs.StateCreating {
+		return nil
+	}
+	err = u.SetExitStatus(code, reason)
+	if err != nil {
+		return err
+	}
+	// The Poststop hooks must run, even if the network was not released
+	err = u.cleanupNetwork()
+	u.executePoststopHooks()
+	return err
+}
+
+// markStopped marks the container as stopped, if it is not already. The reason
+// is stored only if no exit status has been recorded, since the exit code of
+// the VMM is only known to its parent.
+func (u *Unikontainer) markStopped(reason string) error {
+	unlock, err := u.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if u.State.Status == specs.StateStopped {
+		return nil
+	}
+	if u.runtime.ExitReason == "" {
+		u.runtime.ExitReason = reason
+	}
+	u.State.Status = specs.StateStopped
+	return u.saveContainerState()
+}
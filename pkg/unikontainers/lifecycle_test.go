@@ -0,0 +1,149 @@
+// Copyright (c) 2023-2024, Nubificus LTD
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package unikontainers
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nubificus/urunc/pkg/unikontainers/hypervisors"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestUnikontainer(t *testing.T, status specs.ContainerState, pid int) *Unikontainer {
+	t.Helper()
+	u := &Unikontainer{
+		BaseDir: t.TempDir(),
+		Spec:    &specs.Spec{},
+		State: &specs.State{
+			ID:          "test",
+			Status:      status,
+			Pid:         pid,
+			Annotations: map[string]string{annotHypervisor: "qemu"},
+		},
+	}
+	err := u.saveContainerState()
+	assert.NoError(t, err)
+	return u
+}
+
+func TestCheckTransition(t *testing.T) {
+	t.Run("check transition allowed", func(t *testing.T) {
+		t.Parallel()
+		u := newTestUnikontainer(t, specs.StateCreated, os.Getpid())
+		assert.NoError(t, u.checkTransition("start", specs.StateCreated))
+	})
+
+	t.Run("check transition rejected", func(t *testing.T) {
+		t.Parallel()
+		u := newTestUnikontainer(t, specs.StateRunning, os.Getpid())
+		err := u.checkTransition("start", specs.StateCreated)
+		assert.Error(t, err)
+		assert.True(t, errors.Is(err, ErrInvalidTransition), "Expected ErrInvalidTransition")
+		var transitionErr *TransitionError
+		assert.True(t, errors.As(err, &transitionErr), "Expected TransitionError")
+		assert.Equal(t, specs.StateRunning, transitionErr.Status)
+	})
+
+	t.Run("check transition delete created", func(t *testing.T) {
+		t.Parallel()
+		u := newTestUnikontainer(t, specs.StateCreated, os.Getpid())
+		err := u.Delete()
+		assert.True(t, errors.Is(err, ErrInvalidTransition), "Expected delete of created container to be rejected")
+	})
+}
+
+func TestCurrentStateMarksStopped(t *testing.T) {
+	// A PID that is alive, but with a different start time,
+	// belongs to another process
+	u := newTestUnikontainer(t, specs.StateRunning, os.Getpid())
+	u.runtime.StartTime = "0"
+	err := u.saveContainerState()
+	assert.NoError(t, err)
+
+	state := u.CurrentState()
+	assert.Equal(t, specs.StateStopped, state.Status)
+	assert.Equal(t, exitReasonExited, u.runtime.ExitReason)
+
+	saved, err := loadUnikontainerState(filepath.Join(u.BaseDir, stateFilename))
+	assert.NoError(t, err)
+	assert.Equal(t, specs.StateStopped, saved.OCI.Status, "Expected stopped status to be persisted")
+	assert.Equal(t, exitReasonExited, saved.Runtime.ExitReason, "Expected exit reason to be persisted")
+}
+
+func TestReap(t *testing.T) {
+	t.Run("reap records exit status", func(t *testing.T) {
+		out := filepath.Join(t.TempDir(), "poststop")
+		u := newTestUnikontainer(t, specs.StateRunning, 0)
+		u.Spec.Hooks = &specs.Hooks{Poststop: []specs.Hook{shellHook("echo >> "+out, nil)}}
+
+		err := u.Reap(3, exitReasonExited)
+		assert.NoError(t, err)
+		saved, err := loadUnikontainerState(filepath.Join(u.BaseDir, stateFilename))
+		assert.NoError(t, err)
+		assert.Equal(t, specs.StateStopped, saved.OCI.Status)
+		if assert.NotNil(t, saved.Runtime.ExitCode) {
+			assert.Equal(t, 3, *saved.Runtime.ExitCode)
+		}
+		assert.NotNil(t, saved.Runtime.ExitedAt, "Expected the exit time to be recorded")
+		assert.True(t, saved.Runtime.PoststopDone)
+
+		// Delete must not execute the Poststop hooks again
+		err = u.Delete()
+		assert.NoError(t, err)
+		data, err := os.ReadFile(out)
+		assert.NoError(t, err)
+		assert.Equal(t, "\n", string(data), "Expected Poststop hooks to run once")
+	})
+
+	t.Run("reap ignores creating container", func(t *testing.T) {
+		u := newTestUnikontainer(t, specs.StateCreating, 0)
+		err := u.Reap(1, exitReasonExited)
+		assert.NoError(t, err)
+		saved, err := loadUnikontainerState(filepath.Join(u.BaseDir, stateFilename))
+		assert.NoError(t, err)
+		assert.Equal(t, specs.StateCreating, saved.OCI.Status)
+		assert.Nil(t, saved.Runtime.ExitedAt)
+	})
+}
+
+func TestExitStatus(t *testing.T) {
+	tests := []struct {
+		name   string
+		code   int
+		reason string
+		status int
+		result string
+	}{
+		{name: "debug exit", code: 3, reason: exitReasonExited, status: 1, result: exitReasonExited},
+		{name: "qemu error", code: 2, reason: exitReasonExited, status: hypervisors.ExitStatusGuestCrash, result: exitReasonCrashed},
+		// The exit code of a VMM killed by a signal is not translated
+		{name: "signal", code: 139, reason: "killed by signal SIGSEGV", status: 139, result: "killed by signal SIGSEGV"},
+	}
+	for _, arch := range []string{"x86_64", "aarch64"} {
+		fakeVMMBinary(t, hypervisors.QemuBinary+arch)
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			u := newTestUnikontainer(t, specs.StateRunning, 0)
+			status, reason := u.ExitStatus(tc.code, tc.reason)
+			assert.Equal(t, tc.status, status)
+			assert.Equal(t, tc.result, reason)
+		})
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright (c) 2023-2024, Nubificus LTD
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package unikontainers
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/sys/unix"
+)
+
+// lock acquires an exclusive flock on the lock file of the container and
+// reloads the state from state.json, so that concurrent urunc invocations
+// for the same container (e.g. kill, delete and state from containerd) do
+// not overwrite each other's changes. The lock is reentrant and the state is
+// only reloaded by the outermost call. The returned function releases the lock.
+func (u *Unikontainer) lock() (func(), error) {
+	if u.lockDepth > 0 {
+		u.lockDepth++
+		return u.unlock, nil
+	}
+	lockPath := filepath.Join(u.BaseDir, lockFilename)
+	f, err := os.OpenFile(lockPath, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file of container %s: %w", u.State.ID, err)
+	}
+	err = unix.Flock(int(f.Fd()), unix.LOCK_EX)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to lock container %s: %w", u.State.ID, err)
+	}
+	u.lockFile = f
+	u.lockDepth = 1
+
+	cs, err := loadUnikontainerState(filepath.Join(u.BaseDir, stateFilename))
+	if err != nil {
+		u.unlock()
+		return nil, fmt.Errorf("failed to reload state of container %s: %w", u.State.ID, err)
+	}
+	u.State = cs.OCI
+	u.runtime = cs.Runtime
+	return u.unlock, nil
+}
+
+// unlock releases the lock acquired by lock
+func (u *Unikontainer) unlock() {
+	u.lockDepth--
+	if u.lockDepth > 0 {
+		return
+	}
+	err := unix.Flock(int(u.lockFile.Fd()), unix.LOCK_UN)
+	if err != nil {
+		Log.WithError(err).Warn("failed to unlock container")
+	}
+	u.lockFile.Close()
+	u.lockFile = nil
+}
@@ -0,0 +1,73 @@
+// Copyright (c) 2023-2024, Nubificus LTD
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package unikontainers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLock(t *testing.T) {
+	t.Run("lock reentrant", func(t *testing.T) {
+		t.Parallel()
+		u := newTestUnikontainer(t, specs.StateCreated, -1)
+		unlock, err := u.lock()
+		assert.NoError(t, err)
+		nestedUnlock, err := u.lock()
+		assert.NoError(t, err)
+		assert.Equal(t, 2, u.lockDepth)
+		nestedUnlock()
+		assert.NotNil(t, u.lockFile, "Expected lock to be held by the outer call")
+		unlock()
+		assert.Nil(t, u.lockFile, "Expected lock to be released")
+	})
+
+	t.Run("lock excludes other invocations", func(t *testing.T) {
+		t.Parallel()
+		u := newTestUnikontainer(t, specs.StateCreated, -1)
+		// Another urunc invocation loads the same container
+		other := &Unikontainer{BaseDir: u.BaseDir, State: &specs.State{ID: u.State.ID}}
+
+		unlock, err := u.lock()
+		assert.NoError(t, err)
+		locked := make(chan struct{})
+		go func() {
+			otherUnlock, err := other.lock()
+			assert.NoError(t, err)
+			close(locked)
+			otherUnlock()
+		}()
+
+		select {
+		case <-locked:
+			t.Fatal("Expected the second lock to block")
+		case <-time.After(100 * time.Millisecond):
+		}
+		u.State.Status = specs.StateRunning
+		err = u.saveContainerState()
+		assert.NoError(t, err)
+		unlock()
+
+		select {
+		case <-locked:
+		case <-time.After(2 * time.Second):
+			t.Fatal("Expected the second lock to be acquired")
+		}
+		assert.Equal(t, specs.StateRunning, other.State.Status, "Expected state to be reloaded under the lock")
+	})
+}
@@ -0,0 +1,170 @@
+// Copyright (c) 2023-2024, Nubificus LTD
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package unikontainers
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/nubificus/urunc/pkg/network"
+	"github.com/nubificus/urunc/pkg/unikontainers/unikernels"
+	"github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// stateVersion is the schema version of the state document written by this
+// version of urunc. Whenever the format of containerState changes, increase it
+// and add a migration step in loadUnikontainerState, so that containers created
+// by older versions of urunc can still be managed after an upgrade.
+const stateVersion = 1
+
+// legacyTapDevice is the TAP device that urunc used for all containers before
+// the introduction of the versioned state document
+const legacyTapDevice = "tap0_urunc"
+
+// containerState is the document stored in state.json
+type containerState struct {
+	Version int          `json:"version"`
+	OCI     *specs.State `json:"oci"`
+	Runtime runtimeState `json:"runtime"`
+}
+
+// runtimeState holds the information that urunc needs to manage the
+// container during its lifetime, besides the OCI state
+type runtimeState struct {
+	TapDevice      string           `json:"tapDevice,omitempty"`      // The TAP device of the guest
+	NetnsPath      string           `json:"netnsPath,omitempty"`      // The sandbox network namespace, empty if the VMM has its own
+	NetnsInode     uint64           `json:"netnsInode,omitempty"`     // The inode of the sandbox network namespace, to detect a reused PID
+	NATRule        *network.NATRule `json:"natRule,omitempty"`        // The iptables rule added in the network namespace
+	ExtractedFiles []string         `json:"extractedFiles,omitempty"` // The files copied out of the devmapper snapshot
+	VMMPid         int              `json:"vmmPid,omitempty"`         // The PID of the VMM process
+	StartTime      string           `json:"startTime,omitempty"`      // The start time of the container's process, to detect PID reuse
+	ExitCode       *int             `json:"exitCode,omitempty"`       // The exit code of the VMM, if known
+	ExitReason     string           `json:"exitReason,omitempty"`     // The reason the VMM exited
+	ExitedAt       *time.Time       `json:"exitedAt,omitempty"`       // The time the exit status of the VMM was recorded
+	MonitorPid     int              `json:"monitorPid,omitempty"`     // The PID of the process that waits for the VMM, if any
+	PoststopDone   bool             `json:"poststopDone,omitempty"`   // Whether the Poststop hooks have been executed
+	RestoreImage   string           `json:"restoreImage,omitempty"`   // The checkpoint to restore the guest from
+	APIBoot        bool             `json:"apiBoot,omitempty"`        // Whether the guest is booted through the API of the VMM
+	PidNamespace   bool             `json:"pidNamespace,omitempty"`   // Whether the VMM is the init process of its own PID namespace
+}
+
+// loadUnikontainerState reads the state document of a container and migrates
+// it to the current schema version, if it was written by an older urunc
+func loadUnikontainerState(stateFilePath string) (*containerState, error) {
+	data, err := os.ReadFile(stateFilePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var state containerState
+	err = json.Unmarshal(data, &state)
+	if err != nil {
+		return nil, err
+	}
+	if state.OCI == nil {
+		// Unversioned documents are plain OCI states
+		var ociState specs.State
+		err = json.Unmarshal(data, &ociState)
+		if err != nil {
+			return nil, err
+		}
+		// The directory of the container is in the root directory of urunc
+		rootDir := filepath.Dir(filepath.Dir(stateFilePath))
+		state = migrateUnversionedState(&ociState, rootDir)
+	}
+	if state.Version > stateVersion {
+		return nil, fmt.Errorf("state version %d of %s is not supported, latest supported version is %d",
+			state.Version, stateFilePath, stateVersion)
+	}
+	return &state, nil
+}
+
+// migrateUnversionedState converts an OCI state, as stored by urunc before the
+// introduction of the versioned document, to the current schema version
+func migrateUnversionedState(ociState *specs.State, rootDir string) containerState {
+	if ociState.Annotations == nil {
+		ociState.Annotations = make(map[string]string)
+	}
+	annotations := ociState.Annotations
+	runtime := runtimeState{
+		// Older versions always used the same TAP device
+		TapDevice: legacyTapDevice,
+	}
+	if ociState.Status == specs.StateRunning {
+		runtime.VMMPid = ociState.Pid
+	}
+	runtime.NetnsPath = legacyNetnsPath(ociState, rootDir)
+	if runtime.NetnsPath != "" {
+		// The inode can not be recovered, if the namespace is already gone
+		runtime.NetnsInode, _ = netnsInode(runtime.NetnsPath)
+	}
+
+	// Older versions decided whether files were extracted from the devmapper
+	// snapshot based on the annotations
+	unikernel, err := unikernels.New(annotations[annotType])
+	if err == nil && unikernel.SupportsBlock() && annotations[annotBlock] == "" {
+		useDevmapper, err := strconv.ParseBool(annotations[annotUseDMBlock])
+		if err != nil || useDevmapper {
+			runtime.ExtractedFiles = extractedFiles(ociState.Bundle, annotations[annotBinary], annotations[annotInitrd])
+		}
+	}
+	return containerState{
+		Version: stateVersion,
+		OCI:     ociState,
+		Runtime: runtime,
+	}
+}
+
+// legacyNetnsPath returns the network namespace that an older urunc set up the
+// network of the unikernel in. It is the namespace of the spec, the one of the
+// sandbox or, while the VMM is alive, the one of the VMM.
+func legacyNetnsPath(ociState *specs.State, rootDir string) string {
+	spec, err := loadSpec(ociState.Bundle)
+	if err == nil && spec.Linux != nil {
+		for _, ns := range spec.Linux.Namespaces {
+			if ns.Type == specs.NetworkNamespace && ns.Path != "" {
+				return ns.Path
+			}
+		}
+	}
+	netnsPath, err := sandboxNetnsPath(rootDir, ociState.Annotations[annotSandboxID])
+	if err == nil && netnsPath != "" {
+		if _, err := netnsInode(netnsPath); err == nil {
+			return netnsPath
+		}
+	}
+	if ociState.Status == specs.StateRunning && processAlive(ociState.Pid, "") {
+		return fmt.Sprintf("/proc/%d/ns/net", ociState.Pid)
+	}
+	return ""
+}
+
+// extractedFiles returns the paths of the files that are copied out of the
+// devmapper snapshot, when it is used as the block device of the unikernel
+func extractedFiles(bundle string, unikernel string, initrd string) []string {
+	rootfsPath := filepath.Join(bundle, rootfsDirName)
+	files := []string{
+		filepath.Join(rootfsPath, unikernel),
+		filepath.Join(rootfsPath, uruncJSONFilename),
+	}
+	if initrd != "" {
+		files = append(files, filepath.Join(rootfsPath, initrd))
+	}
+	return files
+}
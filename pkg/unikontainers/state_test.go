@@ -0,0 +1,142 @@
+// Copyright (c) 2023-2024, Nubificus LTD
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package unikontainers
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadUnikontainerState(t *testing.T) {
+	t.Run("load state current version", func(t *testing.T) {
+		t.Parallel()
+		u := newTestUnikontainer(t, specs.StateRunning, 42)
+		u.runtime.TapDevice = "tap0_urunc"
+		u.runtime.VMMPid = 42
+		err := u.saveContainerState()
+		assert.NoError(t, err)
+
+		cs, err := loadUnikontainerState(filepath.Join(u.BaseDir, stateFilename))
+		assert.NoError(t, err)
+		assert.Equal(t, stateVersion, cs.Version)
+		assert.Equal(t, u.State, cs.OCI)
+		assert.Equal(t, u.runtime, cs.Runtime)
+	})
+
+	t.Run("load state unversioned migration", func(t *testing.T) {
+		t.Parallel()
+		tmpDir := t.TempDir()
+		statePath := filepath.Join(tmpDir, "test", stateFilename)
+		err := os.MkdirAll(filepath.Dir(statePath), 0o755)
+		assert.NoError(t, err)
+		legacy := specs.State{
+			Version: "1.0.2",
+			ID:      "test",
+			Status:  specs.StateRunning,
+			Pid:     42,
+			Bundle:  "/bundle",
+			Annotations: map[string]string{
+				annotType:       "unikraft",
+				annotHypervisor: "qemu",
+			},
+		}
+		data, err := json.Marshal(legacy)
+		assert.NoError(t, err)
+		err = os.WriteFile(statePath, data, 0600)
+		assert.NoError(t, err)
+
+		cs, err := loadUnikontainerState(statePath)
+		assert.NoError(t, err)
+		assert.Equal(t, stateVersion, cs.Version)
+		assert.Equal(t, "test", cs.OCI.ID)
+		assert.Equal(t, 42, cs.OCI.Pid)
+		assert.Equal(t, legacyTapDevice, cs.Runtime.TapDevice)
+		assert.Equal(t, 42, cs.Runtime.VMMPid)
+		assert.Nil(t, cs.Runtime.ExitCode)
+		// Unikraft does not support block devices
+		assert.Empty(t, cs.Runtime.ExtractedFiles)
+		assert.Equal(t, "qemu", cs.OCI.Annotations[annotHypervisor])
+	})
+
+	t.Run("load state unversioned extracted files", func(t *testing.T) {
+		t.Parallel()
+		legacy := &specs.State{
+			ID:     "test",
+			Status: specs.StateStopped,
+			Bundle: "/bundle",
+			Annotations: map[string]string{
+				annotType:       "rumprun",
+				annotBinary:     "/unikernel",
+				annotUseDMBlock: "true",
+			},
+		}
+		cs := migrateUnversionedState(legacy, t.TempDir())
+		assert.Equal(t, []string{"/bundle/rootfs/unikernel", "/bundle/rootfs/urunc.json"}, cs.Runtime.ExtractedFiles)
+		assert.Equal(t, 0, cs.Runtime.VMMPid)
+	})
+
+	t.Run("load state unversioned network namespace", func(t *testing.T) {
+		t.Parallel()
+		// The bundle of the fixture is relative to the package directory
+		cs, err := loadUnikontainerState(filepath.Join("testdata", "legacy", stateFilename))
+		assert.NoError(t, err)
+		assert.Equal(t, "legacy", cs.OCI.ID)
+		assert.Equal(t, "/var/run/netns/cni-legacy", cs.Runtime.NetnsPath)
+		assert.Zero(t, cs.Runtime.NetnsInode, "Expected no inode for a namespace that is gone")
+	})
+
+	t.Run("load state unversioned vmm network namespace", func(t *testing.T) {
+		t.Parallel()
+		// Without a namespace in the spec, the one of the live VMM is used
+		legacy := &specs.State{
+			ID:          "test",
+			Status:      specs.StateRunning,
+			Pid:         os.Getpid(),
+			Bundle:      t.TempDir(),
+			Annotations: map[string]string{},
+		}
+		cs := migrateUnversionedState(legacy, t.TempDir())
+		netnsPath := fmt.Sprintf("/proc/%d/ns/net", os.Getpid())
+		assert.Equal(t, netnsPath, cs.Runtime.NetnsPath)
+		inode, err := netnsInode(netnsPath)
+		assert.NoError(t, err)
+		assert.Equal(t, inode, cs.Runtime.NetnsInode)
+
+		legacy.Status = specs.StateStopped
+		cs = migrateUnversionedState(legacy, t.TempDir())
+		assert.Empty(t, cs.Runtime.NetnsPath, "Expected no namespace for a stopped VMM")
+	})
+
+	t.Run("load state newer version", func(t *testing.T) {
+		t.Parallel()
+		statePath := filepath.Join(t.TempDir(), stateFilename)
+		data, err := json.Marshal(containerState{
+			Version: stateVersion + 1,
+			OCI:     &specs.State{ID: "test"},
+		})
+		assert.NoError(t, err)
+		err = os.WriteFile(statePath, data, 0600)
+		assert.NoError(t, err)
+
+		_, err = loadUnikontainerState(statePath)
+		assert.Error(t, err, "Expected an error for a state written by a newer urunc")
+	})
+}
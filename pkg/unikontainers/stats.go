@@ -0,0 +1,228 @@
+// Copyright (c) 2023-2024, Nubificus LTD
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package unikontainers
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/nubificus/urunc/pkg/unikontainers/hypervisors"
+)
+
+// The following types follow the format of the events printed by runc,
+// so that urunc containers can be monitored in the same way.
+
+// Event is an event about a container, as printed by the events command
+type Event struct {
+	Type string      `json:"type"`
+	ID   string      `json:"id"`
+	Data interface{} `json:"data,omitempty"`
+}
+
+// Stats holds the resource usage statistics of the VMM process
+type Stats struct {
+	CPU               CPU                 `json:"cpu"`
+	Memory            Memory              `json:"memory"`
+	Pids              Pids                `json:"pids"`
+	NetworkInterfaces []*NetworkInterface `json:"network_interfaces,omitempty"`
+	// The metrics reported by the VMM itself, in its own format
+	VMM json.RawMessage `json:"vmm,omitempty"`
+}
+
+type CPUUsage struct {
+	Total  uint64 `json:"total,omitempty"` // Units: nanoseconds
+	Kernel uint64 `json:"kernel"`
+	User   uint64 `json:"user"`
+}
+
+type Throttling struct {
+	Periods          uint64 `json:"periods,omitempty"`
+	ThrottledPeriods uint64 `json:"throttledPeriods,omitempty"`
+	ThrottledTime    uint64 `json:"throttledTime,omitempty"`
+}
+
+type CPU struct {
+	Usage      CPUUsage   `json:"usage,omitempty"`
+	Throttling Throttling `json:"throttling,omitempty"`
+}
+
+type MemoryEntry struct {
+	Limit   uint64 `json:"limit"`
+	Usage   uint64 `json:"usage,omitempty"`
+	Max     uint64 `json:"max,omitempty"`
+	Failcnt uint64 `json:"failcnt"`
+}
+
+type Memory struct {
+	Cache uint64            `json:"cache,omitempty"`
+	Usage MemoryEntry       `json:"usage,omitempty"`
+	Raw   map[string]uint64 `json:"raw,omitempty"`
+}
+
+type Pids struct {
+	Current uint64 `json:"current,omitempty"`
+	Limit   uint64 `json:"limit,omitempty"`
+}
+
+type NetworkInterface struct {
+	Name      string `json:"name"`
+	RxBytes   uint64 `json:"rx_bytes"`
+	RxPackets uint64 `json:"rx_packets"`
+	RxErrors  uint64 `json:"rx_errors"`
+	RxDropped uint64 `json:"rx_dropped"`
+	TxBytes   uint64 `json:"tx_bytes"`
+	TxPackets uint64 `json:"tx_packets"`
+	TxErrors  uint64 `json:"tx_errors"`
+	TxDropped uint64 `json:"tx_dropped"`
+}
+
+// StatsCollector collects the resource usage statistics of the VMM process
+// of a container from the cgroup of the VMM, which must not hold any other
+// process. The cgroup is resolved once, so that the OOM counters can still be
+// read after the VMM has exited.
+type StatsCollector struct {
+	u       *Unikontainer
+	cgroup  *processCgroup
+	metrics hypervisors.MetricsReporter
+}
+
+// NewStatsCollector returns a StatsCollector for the VMM of the container
+func (u *Unikontainer) NewStatsCollector() (*StatsCollector, error) {
+	if hypervisors.VmmType(u.State.Annotations[annotHypervisor]) == hypervisors.HedgeVmm {
+		return nil, fmt.Errorf("cannot collect stats for container %s: %w", u.State.ID, hypervisors.ErrNotSupported)
+	}
+	if !u.isRunning() {
+		return nil, fmt.Errorf("container %s is not running", u.State.ID)
+	}
+	vmmPid := u.State.Pid
+	if u.runtime.VMMPid > 0 {
+		vmmPid = u.runtime.VMMPid
+	}
+	cg, err := getProcessCgroup(vmmPid)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get cgroup of process %d: %w", vmmPid, err)
+	}
+	// The statistics of a cgroup that is shared with other processes (e.g.
+	// the shim) would not be the ones of the VMM
+	dedicated, err := cg.dedicated(vmmPid)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the processes in the cgroup of process %d: %w", vmmPid, err)
+	}
+	if !dedicated {
+		return nil, fmt.Errorf("cannot collect stats for container %s: the VMM does not run in a dedicated cgroup", u.State.ID)
+	}
+	collector := &StatsCollector{u: u, cgroup: cg}
+	vmm, err := hypervisors.NewVMM(hypervisors.VmmType(u.State.Annotations[annotHypervisor]))
+	if err == nil {
+		collector.metrics, _ = vmm.(hypervisors.MetricsReporter)
+	}
+	return collector, nil
+}
+
+// Running returns true if the VMM process is still alive
+func (c *StatsCollector) Running() bool {
+	return c.u.isRunning()
+}
+
+// OOMKillCount returns the number of OOM kills in the cgroup of the VMM
+func (c *StatsCollector) OOMKillCount() uint64 {
+	return c.cgroup.oomKillCount()
+}
+
+// Stats returns the current resource usage statistics of the VMM. Statistics
+// that are not available (e.g. a missing cgroup controller) are left empty.
+func (c *StatsCollector) Stats() (*Stats, error) {
+	var err error
+	stats := &Stats{}
+	stats.CPU, err = c.cgroup.cpuStats()
+	if err != nil {
+		Log.WithError(err).Debug("failed to read cpu stats")
+	}
+	stats.Memory, err = c.cgroup.memoryStats()
+	if err != nil {
+		Log.WithError(err).Debug("failed to read memory stats")
+	}
+	stats.Pids, err = c.cgroup.pidsStats()
+	if err != nil {
+		Log.WithError(err).Debug("failed to read pids stats")
+	}
+	stats.NetworkInterfaces, err = getTapStats(c.u.State.Pid)
+	if err != nil {
+		return nil, err
+	}
+	if c.metrics != nil {
+		stats.VMM, err = c.metrics.Metrics(c.u.BaseDir)
+		if err != nil && !errors.Is(err, hypervisors.ErrNotSupported) {
+			Log.WithError(err).Debug("failed to read vmm metrics")
+		}
+	}
+	return stats, nil
+}
+
+// getTapStats reads the statistics of the tap devices in the network namespace
+// of the given process. The statistics are reported from the guest's point of view,
+// hence the received and transmitted counters of the tap devices are swapped.
+func getTapStats(pid int) ([]*NetworkInterface, error) {
+	file, err := os.Open(filepath.Join("/proc", strconv.Itoa(pid), "net", "dev"))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var ifaces []*NetworkInterface
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// Every line has the format: "name: rx_bytes rx_packets rx_errs
+		// rx_drop fifo frame compressed multicast tx_bytes tx_packets
+		// tx_errs tx_drop ...". The first two lines are headers.
+		parts := strings.SplitN(scanner.Text(), ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		name := strings.TrimSpace(parts[0])
+		if !strings.Contains(name, "tap") {
+			continue
+		}
+		fields := strings.Fields(parts[1])
+		if len(fields) < 12 {
+			continue
+		}
+		values := make([]uint64, 12)
+		for i := range values {
+			values[i], err = strconv.ParseUint(fields[i], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid statistics for %s: %w", name, err)
+			}
+		}
+		ifaces = append(ifaces, &NetworkInterface{
+			Name:      name,
+			RxBytes:   values[8],
+			RxPackets: values[9],
+			RxErrors:  values[10],
+			RxDropped: values[11],
+			TxBytes:   values[0],
+			TxPackets: values[1],
+			TxErrors:  values[2],
+			TxDropped: values[3],
+		})
+	}
+	return ifaces, scanner.Err()
+}
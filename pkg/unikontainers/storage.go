@@ -23,6 +23,8 @@ import (
 	"strings"
 
 	"github.com/moby/sys/mount"
+	"github.com/nubificus/urunc/pkg/unikontainers/hypervisors"
+	"github.com/opencontainers/runtime-spec/specs-go"
 	"github.com/sirupsen/logrus"
 )
 
@@ -168,3 +170,48 @@ func cleanupExtractedFiles(bundle string) error {
 	rootfsPath := filepath.Join(bundle, rootfsDirName)
 	return os.RemoveAll(rootfsPath)
 }
+
+// blockVolumes returns the block devices of the container spec, which are
+// attached to the guest as additional drives. These are bind mounts of a
+// block device (read-only if mounted with "ro") and block device nodes (e.g.
+// raw block volumes), which are found on the host through /dev/block.
+func blockVolumes(spec *specs.Spec) []hypervisors.Volume {
+	var volumes []hypervisors.Volume
+	for _, m := range spec.Mounts {
+		if !isBlockDevice(m.Source) {
+			continue
+		}
+		volume := hypervisors.Volume{Path: m.Source}
+		for _, option := range m.Options {
+			if option == "ro" {
+				volume.ReadOnly = true
+			}
+		}
+		volumes = append(volumes, volume)
+	}
+	if spec.Linux == nil {
+		return volumes
+	}
+	for _, device := range spec.Linux.Devices {
+		if device.Type != "b" {
+			continue
+		}
+		hostPath := fmt.Sprintf("/dev/block/%d:%d", device.Major, device.Minor)
+		if !isBlockDevice(hostPath) {
+			Log.Warnf("block device %s of the container was not found at %s", device.Path, hostPath)
+			continue
+		}
+		volumes = append(volumes, hypervisors.Volume{Path: hostPath})
+	}
+	return volumes
+}
+
+// isBlockDevice returns true if path is a block device
+func isBlockDevice(path string) bool {
+	info, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	mode := info.Mode()
+	return mode&os.ModeDevice != 0 && mode&os.ModeCharDevice == 0
+}
@@ -15,9 +15,14 @@
 package unikontainers
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
 
+	"github.com/nubificus/urunc/pkg/unikontainers/hypervisors"
+	"github.com/opencontainers/runtime-spec/specs-go"
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
 )
 
 func TestGetBlockDevice(t *testing.T) {
@@ -34,3 +39,28 @@ func TestGetBlockDevice(t *testing.T) {
 	assert.Equal(t, tmpMnt.Device, rootFs.Device, "Expected device to be dm-0")
 	assert.Equal(t, tmpMnt.FsType, rootFs.FsType, "Expected filesystem type to be ext4")
 }
+
+func TestBlockVolumes(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("creating a block device node requires root")
+	}
+	tmpDir := t.TempDir()
+	blockDev := filepath.Join(tmpDir, "loop")
+	err := unix.Mknod(blockDev, unix.S_IFBLK|0o600, int(unix.Mkdev(7, 0)))
+	assert.NoError(t, err)
+
+	spec := &specs.Spec{
+		Mounts: []specs.Mount{
+			{Destination: "/data", Source: blockDev, Options: []string{"bind", "ro"}},
+			{Destination: "/etc/hosts", Source: filepath.Join(tmpDir, "missing")},
+			{Destination: "/tmp", Source: tmpDir, Options: []string{"bind"}},
+		},
+		Linux: &specs.Linux{
+			Devices: []specs.LinuxDevice{
+				{Path: "/dev/null", Type: "c", Major: 1, Minor: 3},
+			},
+		},
+	}
+	volumes := blockVolumes(spec)
+	assert.Equal(t, []hypervisors.Volume{{Path: blockDev, ReadOnly: true}}, volumes)
+}
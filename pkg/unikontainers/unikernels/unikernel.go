@@ -36,6 +36,12 @@ type UnikernelParams struct {
 
 var ErrNotSupportedUnikernel = errors.New("unikernel is not supported")
 
+// SupportedUnikernels returns the unikernel types that New knows how to create.
+// Always keep it in sync with the switch statement in New.
+func SupportedUnikernels() []string {
+	return []string{RumprunUnikernel, UnikraftUnikernel}
+}
+
 func New(unikernelType string) (Unikernel, error) {
 	switch unikernelType {
 	case RumprunUnikernel:
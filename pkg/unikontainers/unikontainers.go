@@ -15,16 +15,15 @@
 package unikontainers
 
 import (
-	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"runtime"
 	"strconv"
-	"sync"
 	"syscall"
+	"time"
 
 	"github.com/nubificus/urunc/pkg/network"
 	"github.com/nubificus/urunc/pkg/unikontainers/hypervisors"
@@ -43,12 +42,23 @@ var Log = logrus.WithField("subsystem", "unikontainers")
 var ErrQueueProxy = errors.New("This a queue proxy container")
 var ErrNotUnikernel = errors.New("This is not a unikernel container")
 
+// statePaused indicates that the execution of the guest has been paused.
+// It is not part of the OCI spec, but it is used by runc and containerd.
+const statePaused specs.ContainerState = "paused"
+
+// annotSandboxID holds the ID of the sandbox (pod) of a container
+const annotSandboxID = "io.kubernetes.cri.sandbox-id"
+
 // Unikontainer holds the data necessary to create, manage and delete unikernel containers
 type Unikontainer struct {
 	State   *specs.State
 	Spec    *specs.Spec
 	BaseDir string
 	RootDir string
+
+	runtime   runtimeState // The runtime information stored along with the OCI state
+	lockFile  *os.File     // The open lock file, while the container is locked
+	lockDepth int          // The number of nested lock calls
 }
 
 // New parses the bundle and creates a new Unikontainer object
@@ -98,14 +108,16 @@ func Get(containerID string, rootDir string) (*Unikontainer, error) {
 	u := &Unikontainer{}
 	containerDir := filepath.Join(rootDir, containerID)
 	stateFilePath := filepath.Join(containerDir, stateFilename)
-	state, err := loadUnikontainerState(stateFilePath)
+	cs, err := loadUnikontainerState(stateFilePath)
 	if err != nil {
 		return nil, err
 	}
+	state := cs.OCI
 	if state.Annotations[annotType] == "" {
 		return nil, ErrNotUnikernel
 	}
 	u.State = state
+	u.runtime = cs.Runtime
 
 	spec, err := loadSpec(state.Bundle)
 	if err != nil {
@@ -130,22 +142,53 @@ func (u *Unikontainer) InitialSetup() error {
 	return u.saveContainerState()
 }
 
+// SetRestoreImage marks the container to be restored from the checkpoint
+// in imageDir. It must be called before InitialSetup.
+func (u *Unikontainer) SetRestoreImage(imageDir string) {
+	u.runtime.RestoreImage = imageDir
+}
+
 // Create sets the Unikernel status as created,
 // and saves the given PID in init.pid
 func (u *Unikontainer) Create(pid int) error {
-	err := writePidFile(filepath.Join(u.State.Bundle, initPidFilename), pid)
+	unlock, err := u.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	err = writePidFile(filepath.Join(u.State.Bundle, initPidFilename), pid)
 	if err != nil {
 		return err
 	}
 	u.State.Pid = pid
 	u.State.Status = specs.StateCreated
+	err = u.recordStartTime()
+	if err != nil {
+		return err
+	}
 	return u.saveContainerState()
 }
 
+// WritePidFile atomically writes the PID of the container's process to the
+// given path. The PID is the one of the reexec process, which later execve's
+// the VMM, so it remains valid for the whole lifetime of the VMM. It is the
+// PID of the state, even if the VMM has a monitor.
+func (u *Unikontainer) WritePidFile(path string) error {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	return writePidFile(absPath, u.State.Pid)
+}
+
 func (u *Unikontainer) Exec() error {
 	// FIXME: We need to find a way to set the output file
 	var metrics = m.NewZerologMetrics(constants.TimestampTargetFile)
-	err := u.joinSandboxNetNs()
+	netnsPath, err := u.sandboxNetnsPath()
+	if err != nil {
+		return err
+	}
+	err = u.joinSandboxNetNs()
 	if err != nil {
 		return err
 	}
@@ -172,11 +215,14 @@ func (u *Unikontainer) Exec() error {
 	vmmArgs := hypervisors.ExecArgs{
 		Container:     u.State.ID,
 		UnikernelPath: unikernelAbsPath,
+		RootfsDir:     rootfsDir,
 		InitrdPath:    initrdAbsPath,
 		BlockDevice:   "",
 		Seccomp:       true, // Enable Seccomp by default
 		MemSizeB:      0,
 		Environment:   os.Environ(),
+		StateDir:      u.BaseDir,
+		SnapshotDir:   u.runtime.RestoreImage,
 	}
 
 	// Check if memory limit was not set
@@ -258,7 +304,11 @@ func (u *Unikontainer) Exec() error {
 	if u.State.Annotations[annotBlock] != "" && unikernel.SupportsBlock() {
 		vmmArgs.BlockDevice = filepath.Join(rootfsDir, u.State.Annotations[annotBlock])
 	}
+	if unikernel.SupportsBlock() {
+		vmmArgs.Volumes = blockVolumes(u.Spec)
+	}
 
+	var extracted []string
 	if unikernel.SupportsBlock() && vmmArgs.BlockDevice == "" && useDevmapper {
 		rootFsDevice, err := getBlockDevice(rootfsDir)
 		if err != nil {
@@ -269,9 +319,14 @@ func (u *Unikontainer) Exec() error {
 			if err != nil {
 				return err
 			}
+			extracted = extractedFiles(u.State.Bundle, unikernelPath, initrdPath)
 			vmmArgs.BlockDevice = rootFsDevice.Device
+			vmmArgs.RootDevice = true
 		}
 	}
+	if vmmArgs.BlockDevice != "" && u.Spec.Root != nil {
+		vmmArgs.BlockDeviceRO = u.Spec.Root.Readonly
+	}
 	metrics.Capture(u.State.ID, "TS18")
 
 	// get a new vmm
@@ -279,6 +334,15 @@ func (u *Unikontainer) Exec() error {
 	if err != nil {
 		return err
 	}
+	if _, ok := vmm.(hypervisors.Snapshotter); vmmArgs.SnapshotDir != "" && !ok {
+		return fmt.Errorf("cannot restore container %s: %w", u.State.ID, hypervisors.ErrNotSupported)
+	}
+	if hypervisors.VmmType(vmmType) == hypervisors.FirecrackerVmm {
+		err = firecrackerOptions(u.Spec, &vmmArgs)
+		if err != nil {
+			return err
+		}
+	}
 
 	err = unikernel.Init(unikernelParams)
 	if err == unikernels.ErrUndefinedVersion || err == unikernels.ErrVersionParsing {
@@ -294,9 +358,31 @@ func (u *Unikontainer) Exec() error {
 	vmmArgs.Command = unikernelCmd
 
 	// update urunc.json state
+	unlock, err := u.lock()
+	if err != nil {
+		return err
+	}
 	u.State.Status = "running"
-	u.State.Pid = os.Getpid()
+	u.State.Pid = SelfPid()
+	u.runtime.VMMPid = u.State.Pid
+	u.runtime.TapDevice = vmmArgs.TapDevice
+	u.runtime.NetnsPath = netnsPath
+	if netnsPath != "" {
+		u.runtime.NetnsInode, err = netnsInode(netnsPath)
+		if err != nil {
+			unlock()
+			return err
+		}
+	}
+	if networkInfo != nil {
+		u.runtime.NATRule = networkInfo.NATRule
+	}
+	u.runtime.ExtractedFiles = extracted
+	u.runtime.APIBoot = vmmArgs.APIBoot && vmmArgs.SnapshotDir == ""
+	// The reexec process of a jailed VMM is spawned in a new PID namespace
+	u.runtime.PidNamespace = vmmArgs.Jailer != nil
 	err = u.saveContainerState()
+	unlock()
 	if err != nil {
 		return err
 	}
@@ -313,281 +399,495 @@ func (u *Unikontainer) Exec() error {
 	return vmm.Execve(vmmArgs)
 }
 
-// Kill stops the VMM process, first by asking the VMM struct to stop
-// and consequently by killing the process described in u.State.Pid
-func (u *Unikontainer) Kill() error {
-	vmmType := u.State.Annotations[annotHypervisor]
-	vmm, err := hypervisors.NewVMM(hypervisors.VmmType(vmmType))
+// Kill sends the given signal to the VMM process. If the VMM supports it,
+// SIGTERM and SIGINT are translated to a graceful shutdown request for the guest
+// and SIGKILL stops the VMM through its API, so that it releases its resources.
+// A VMM that is the init process of its PID namespace drops the signals it does
+// not handle, so any signal but SIGKILL, SIGSTOP and SIGCONT stops it instead.
+// If all is set, the signal is also delivered to any process spawned by the VMM.
+// The network resources are released only after the VMM process has exited.
+// If the container is monitored, Kill waits for the monitor to record the exit.
+func (u *Unikontainer) Kill(sig unix.Signal, all bool) error {
+	err := u.signal(sig, all)
 	if err != nil {
 		return err
 	}
-	err = vmm.Stop(u.State.ID)
+	// The monitor needs the lock to record the exit of the VMM, so it can
+	// only be awaited once the lock is released
+	if u.lockDepth == 0 && u.monitored() && !processAlive(u.State.Pid, "") {
+		if !waitForExit(u.runtime.MonitorPid, killWaitTimeout) {
+			Log.WithField("id", u.State.ID).Debug("monitor has not recorded the exit of the VMM")
+		}
+	}
+	return nil
+}
+
+// signal delivers the given signal to the VMM process, as described in Kill,
+// and waits for the VMM to exit
+func (u *Unikontainer) signal(sig unix.Signal, all bool) error {
+	unlock, err := u.lock()
 	if err != nil {
 		return err
 	}
+	defer unlock()
 
-	// Check if pid is running
-	if syscall.Kill(u.State.Pid, syscall.Signal(0)) == nil {
-		err = syscall.Kill(u.State.Pid, unix.SIGKILL)
+	vmmType := hypervisors.VmmType(u.State.Annotations[annotHypervisor])
+	var vmm hypervisors.VMM
+	vmm, err = hypervisors.NewVMM(vmmType)
+	// Hedge VMs are not backed by a process, so we can only stop them
+	if vmmType == hypervisors.HedgeVmm {
 		if err != nil {
 			return err
 		}
+		return vmm.Stop(u.BaseDir)
 	}
-	// If PID is running we need to kill the process
-	// Once the process is dead, we need to enter the network namespace
-	// and delete the TC rules and TAP device
-	err = u.joinSandboxNetNs()
 	if err != nil {
-		Log.Errorf("failed to join sandbox netns: %v", err)
+		// We can still signal the VMM process, even if the VMM
+		// is not available anymore
+		Log.WithError(err).Warn("failed to get vmm, falling back to signal delivery")
+	}
+
+	if !u.isRunning() {
+		Log.WithField("id", u.State.ID).Debug("VMM has already exited")
+		err = u.markStopped(exitReasonExited)
+		if err != nil {
+			return err
+		}
+		return u.cleanupNetwork()
+	}
+
+	// A paused guest can not handle any signal or shutdown request
+	if u.State.Status == statePaused && sig != unix.SIGKILL {
+		err = u.Resume()
+		if err != nil {
+			return err
+		}
+	}
+
+	// The descendants of the VMM are reparented once it exits
+	pids := []int{u.State.Pid}
+	if all {
+		pids = append(pids, getDescendants(u.State.Pid)...)
+	}
+	delivered := false
+	switch {
+	case u.runtime.PidNamespace && sig != unix.SIGKILL && sig != unix.SIGSTOP && sig != unix.SIGCONT:
+		err = hypervisors.ErrNotSupported
+		if vmm != nil {
+			// Firecracker asks the guest to shut down and kills the
+			// VMM, if the guest does not shut down in time
+			err = vmm.Stop(u.BaseDir)
+		}
+		if err != nil {
+			Log.WithError(err).Warn("failed to stop vmm, killing it")
+			err = unix.Kill(u.State.Pid, unix.SIGKILL)
+			if err != nil && !errors.Is(err, unix.ESRCH) {
+				return fmt.Errorf("failed to kill process %d: %w", u.State.Pid, err)
+			}
+		}
+		delivered = true
+	case (sig == unix.SIGTERM || sig == unix.SIGINT) && !all:
+		if s, ok := vmm.(hypervisors.Shutdowner); ok {
+			err = s.Shutdown(u.BaseDir)
+			if err == nil {
+				delivered = true
+			} else {
+				Log.WithError(err).Warn("graceful shutdown failed, falling back to signal delivery")
+			}
+		}
+	case sig == unix.SIGKILL && vmm != nil:
+		err = vmm.Stop(u.BaseDir)
+		if err == nil {
+			delivered = true
+		} else if !errors.Is(err, hypervisors.ErrNotSupported) {
+			Log.WithError(err).Warn("failed to stop vmm, falling back to signal delivery")
+		}
+	}
+	if delivered {
+		pids = pids[1:]
+	}
+	for _, pid := range pids {
+		err = unix.Kill(pid, sig)
+		if err != nil && !errors.Is(err, unix.ESRCH) {
+			return fmt.Errorf("failed to send signal %d to process %d: %w", sig, pid, err)
+		}
+	}
+
+	// The monitor, if any, reaps the VMM and releases its resources
+	if u.monitored() {
+		if !waitForExit(u.State.Pid, killWaitTimeout) {
+			Log.WithField("id", u.State.ID).Debug("VMM is still running")
+		}
+		return nil
+	}
+
+	// Once the VMM process is dead, we need to enter the network namespace
+	// and delete the TC rules and TAP device. In case the VMM is still
+	// alive (e.g. the guest is shutting down), the cleanup takes place in Delete.
+	if !waitForExit(u.State.Pid, killWaitTimeout) {
+		Log.WithField("id", u.State.ID).Debug("VMM is still running, skipping network cleanup")
 		return nil
 	}
-	// TODO: tap0_urunc should not be hardcoded
-	err = network.Cleanup("tap0_urunc")
+	err = u.markStopped(exitReasonKilled)
 	if err != nil {
-		Log.Errorf("failed to delete tap0_urunc: %v", err)
+		return err
 	}
-	return nil
+	return u.cleanupNetwork()
 }
 
-// Delete removes the containers base directory and its contents
-func (u *Unikontainer) Delete() error {
-	if u.isRunning() {
-		return fmt.Errorf("cannot delete running unikernel: %s", u.State.ID)
+// Pause pauses the execution of the guest. It uses the VMM specific mechanism,
+// if one exists, otherwise it stops the VMM process with SIGSTOP. The latter is
+// preferred over a cgroup freezer, since the VMM does not run in a dedicated cgroup.
+func (u *Unikontainer) Pause() error {
+	unlock, err := u.lock()
+	if err != nil {
+		return err
 	}
-	unikernelType := u.State.Annotations[annotType]
-	unikernel, err := unikernels.New(unikernelType)
+	defer unlock()
+
+	err = u.checkTransition("pause", specs.StateRunning)
 	if err != nil {
 		return err
 	}
-	useDevmapper := false
-	useDevmapper, err = strconv.ParseBool(u.State.Annotations[annotUseDMBlock])
+	vmmType := hypervisors.VmmType(u.State.Annotations[annotHypervisor])
+	vmm, err := hypervisors.NewVMM(vmmType)
 	if err != nil {
-		useDevmapper = true
+		return err
 	}
-	annotBlock := u.State.Annotations[annotBlock]
-	if unikernel.SupportsBlock() && annotBlock == "" && useDevmapper {
-		err := cleanupExtractedFiles(u.State.Bundle)
-		if err != nil {
-			return fmt.Errorf("cannot delete bundle %s: %v", u.State.Bundle, err)
-		}
+	if vmmType == hypervisors.HedgeVmm {
+		return fmt.Errorf("cannot pause container %s: %w", u.State.ID, hypervisors.ErrNotSupported)
 	}
-	return os.RemoveAll(u.BaseDir)
-}
 
-// joinSandboxNetns finds the sandbox id of the container, retrieves the sandbox's init pid,
-// finds the init pid netns and joins it
-func (u Unikontainer) joinSandboxNetNs() error {
-	sandboxID := u.Spec.Annotations["io.kubernetes.cri.sandbox-id"]
-	if sandboxID == "" {
-		return nil
+	err = hypervisors.ErrNotSupported
+	if p, ok := vmm.(hypervisors.Pauser); ok {
+		err = p.Pause(u.BaseDir)
 	}
-	containerDir := filepath.Join(u.RootDir, sandboxID)
-	stateFilePath := filepath.Join(containerDir, stateFilename)
-	sandboxInitPid, err := getInitPid(stateFilePath)
+	if errors.Is(err, hypervisors.ErrNotSupported) {
+		err = unix.Kill(u.State.Pid, unix.SIGSTOP)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to pause container %s: %w", u.State.ID, err)
+	}
+	u.State.Status = statePaused
+	return u.saveContainerState()
+}
+
+// Resume resumes the execution of a paused guest, using the same mechanism as Pause
+func (u *Unikontainer) Resume() error {
+	unlock, err := u.lock()
 	if err != nil {
 		return err
 	}
-	sandboxInitNetns, err := netns.GetFromPid(int(sandboxInitPid))
+	defer unlock()
+
+	err = u.checkTransition("resume", statePaused)
 	if err != nil {
 		return err
 	}
-	Log.WithFields(logrus.Fields{
-		"sandboxInitPid":   sandboxInitPid,
-		"sandboxInitNetns": sandboxInitNetns,
-	}).Info("Joining sandbox's netns")
-	err = netns.Set(sandboxInitNetns)
+	vmmType := hypervisors.VmmType(u.State.Annotations[annotHypervisor])
+	vmm, err := hypervisors.NewVMM(vmmType)
 	if err != nil {
 		return err
 	}
-	Log.Info("Joined sandbox's netns")
-	return nil
-}
 
-// Saves current Unikernel state as baseDir/state.json for later use
-func (u *Unikontainer) saveContainerState() error {
-	// Propagate all annotations from spec to state to solve nerdctl hooks errors.
-	// For more info: https://github.com/containerd/nerdctl/issues/133
-	for key, value := range u.Spec.Annotations {
-		if _, ok := u.State.Annotations[key]; !ok {
-			u.State.Annotations[key] = value
-		}
+	err = hypervisors.ErrNotSupported
+	if p, ok := vmm.(hypervisors.Pauser); ok {
+		err = p.Resume(u.BaseDir)
+	}
+	if errors.Is(err, hypervisors.ErrNotSupported) {
+		err = unix.Kill(u.State.Pid, unix.SIGCONT)
 	}
+	if err != nil {
+		return fmt.Errorf("failed to resume container %s: %w", u.State.ID, err)
+	}
+	u.State.Status = specs.StateRunning
+	return u.saveContainerState()
+}
 
-	data, err := json.Marshal(u.State)
+// Checkpoint saves a snapshot of the guest in imageDir. Unless leaveRunning
+// is set, the VMM is killed after the snapshot has been created.
+func (u *Unikontainer) Checkpoint(imageDir string, leaveRunning bool) error {
+	unlock, err := u.lock()
 	if err != nil {
 		return err
 	}
+	defer unlock()
 
-	stateName := filepath.Join(u.BaseDir, stateFilename)
-	return os.WriteFile(stateName, data, 0o644) //nolint: gosec
-}
-
-func (u *Unikontainer) ExecuteHooks(name string) error {
-	// NOTICE: This wrapper function provides an easy way to toggle between
-	// the sequential and concurrent hook execution. By default the hooks are executed concurrently.
-	// To execute hooks sequentially, change the following line to:
-	// if false
-	if true {
-		return u.executeHooksConcurrently(name)
+	err = u.checkTransition("checkpoint", specs.StateRunning, statePaused)
+	if err != nil {
+		return err
+	}
+	status := u.State.Status
+	vmmType := hypervisors.VmmType(u.State.Annotations[annotHypervisor])
+	vmm, err := hypervisors.NewVMM(vmmType)
+	if err != nil {
+		return err
+	}
+	s, ok := vmm.(hypervisors.Snapshotter)
+	if !ok {
+		return fmt.Errorf("cannot checkpoint container %s: %w", u.State.ID, hypervisors.ErrNotSupported)
 	}
-	return u.executeHooksSequentially(name)
-}
 
-// ExecuteHooks executes concurrently any hooks found in spec based on name:
-func (u *Unikontainer) executeHooksConcurrently(name string) error {
-	// NOTICE: It is possible that the concurrent execution of the hooks may cause
-	// some unknown problems down the line. Be sure to prioritize checking with sequential
-	// hook execution when debugging.
+	err = os.MkdirAll(imageDir, 0o755)
+	if err != nil {
+		return err
+	}
+	// The VMM pauses the guest to take the snapshot
+	err = s.Snapshot(u.BaseDir, imageDir)
+	if err != nil {
+		return fmt.Errorf("failed to checkpoint container %s: %w", u.State.ID, err)
+	}
+	u.State.Status = statePaused
+	err = u.saveContainerState()
+	if err != nil {
+		return err
+	}
 
-	// More info for individual hooks can be found here:
-	// https://github.com/opencontainers/runtime-spec/blob/main/config.md#posix-platform-hooks
-	Log.Infof("Executing %s hooks", name)
-	if u.Spec.Hooks == nil {
-		return nil
+	if !leaveRunning {
+		return u.Kill(unix.SIGKILL, false)
 	}
-	hooks := map[string][]specs.Hook{
-		"Prestart":        u.Spec.Hooks.Prestart,
-		"CreateRuntime":   u.Spec.Hooks.CreateRuntime,
-		"CreateContainer": u.Spec.Hooks.CreateContainer,
-		"StartContainer":  u.Spec.Hooks.StartContainer,
-		"Poststart":       u.Spec.Hooks.Poststart,
-		"Poststop":        u.Spec.Hooks.Poststop,
-	}[name]
-
-	if len(hooks) == 0 {
-		Log.WithFields(logrus.Fields{
-			"id":    u.State.ID,
-			"name:": name,
-		}).Debug("No hooks")
+	if status == statePaused {
 		return nil
 	}
+	return u.Resume()
+}
 
-	s, err := json.Marshal(u.State)
+// BootVMM configures and boots the guest through the API of the VMM, if the
+// VMM was started in API mode. It is a no-op for any other container.
+func (u *Unikontainer) BootVMM() error {
+	if !u.runtime.APIBoot {
+		return nil
+	}
+	vmmType := hypervisors.VmmType(u.State.Annotations[annotHypervisor])
+	vmm, err := hypervisors.NewVMM(vmmType)
 	if err != nil {
 		return err
 	}
-	var wg sync.WaitGroup
-	errChan := make(chan error, len(hooks))
+	b, ok := vmm.(hypervisors.APIBooter)
+	if !ok {
+		return fmt.Errorf("cannot boot container %s through the API: %w", u.State.ID, hypervisors.ErrNotSupported)
+	}
+	err = b.Boot(u.BaseDir)
+	if err != nil {
+		return fmt.Errorf("failed to boot container %s: %w", u.State.ID, err)
+	}
+	return nil
+}
 
-	for _, hook := range hooks {
-		wg.Add(1)
-		go u.executeHook(hook, s, &wg, errChan)
+// RestoreSnapshot loads the checkpoint of a restored container into the VMM.
+// It is a no-op for containers that are not restored from a checkpoint.
+func (u *Unikontainer) RestoreSnapshot() error {
+	imageDir := u.runtime.RestoreImage
+	if imageDir == "" {
+		return nil
 	}
-	wg.Wait()
-	close(errChan)
-	for err := range errChan {
-		Log.WithField("error", err.Error()).Error("failed to execute hooks")
+	vmmType := hypervisors.VmmType(u.State.Annotations[annotHypervisor])
+	vmm, err := hypervisors.NewVMM(vmmType)
+	if err != nil {
 		return err
 	}
+	s, ok := vmm.(hypervisors.Snapshotter)
+	if !ok {
+		return fmt.Errorf("cannot restore container %s: %w", u.State.ID, hypervisors.ErrNotSupported)
+	}
+	err = s.Restore(u.BaseDir, imageDir)
+	if err != nil {
+		return fmt.Errorf("failed to restore container %s from %s: %w", u.State.ID, imageDir, err)
+	}
 	return nil
 }
 
-func (u *Unikontainer) executeHook(hook specs.Hook, state []byte, wg *sync.WaitGroup, errChan chan<- error) {
-	defer wg.Done()
-
-	var stdout, stderr bytes.Buffer
-	cmd := exec.Cmd{
-		Path:   hook.Path,
-		Args:   hook.Args,
-		Env:    hook.Env,
-		Stdin:  bytes.NewReader(state),
-		Stdout: &stdout,
-		Stderr: &stderr,
+// cleanupNetwork joins the sandbox's network namespace and deletes the
+// TC rules and the TAP device of the unikernel. If the VMM had its own
+// network namespace, the TAP device was destroyed along with it.
+func (u *Unikontainer) cleanupNetwork() error {
+	tapDevice := u.runtime.TapDevice
+	natRule := u.runtime.NATRule
+	if (tapDevice == "" && natRule == nil) || u.runtime.NetnsPath == "" {
+		return nil
 	}
-
-	Log.WithFields(logrus.Fields{
-		"cmd":  cmd.String(),
-		"path": hook.Path,
-		"args": hook.Args,
-		"env":  hook.Env,
-	}).Info("executing hook")
-
-	if err := cmd.Run(); err != nil {
-		Log.WithFields(logrus.Fields{
-			"id":     u.State.ID,
-			"error":  err.Error(),
-			"cmd":    cmd.String(),
-			"stderr": stderr.String(),
-			"stdout": stdout.String(),
-		}).Error("failed to execute hook")
-		errChan <- fmt.Errorf("failed to execute hook '%s': %w", cmd.String(), err)
-	}
-}
-
-// ExecuteHooks executes sequentially any hooks found in spec based on name:
-func (u *Unikontainer) executeHooksSequentially(name string) error {
-	// NOTICE: This function is left on purpose to aid future debugging efforts
-	// in case concurrent hook execution causes unexpected errors.
-
-	// More info for individual hooks can be found here:
-	// https://github.com/opencontainers/runtime-spec/blob/main/config.md#posix-platform-hooks
-	Log.Infof("Executing %s hooks", name)
-	if u.Spec.Hooks == nil {
+	// The sandbox might be gone and its init PID reused, so make sure
+	// that this is still the namespace the network was set up in
+	if u.runtime.NetnsInode != 0 {
+		inode, err := netnsInode(u.runtime.NetnsPath)
+		if err != nil || inode != u.runtime.NetnsInode {
+			Log.Warnf("sandbox netns %s is gone, nothing to clean up", u.runtime.NetnsPath)
+			return u.forgetNetwork()
+		}
+	}
+	ns, err := netns.GetFromPath(u.runtime.NetnsPath)
+	if err != nil {
+		Log.Errorf("failed to get sandbox netns %s: %v", u.runtime.NetnsPath, err)
+		return nil
+	}
+	defer ns.Close()
+	// Return to the original netns afterwards, since the caller (e.g. gc)
+	// might go on with other containers
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	origNs, err := netns.Get()
+	if err != nil {
+		return fmt.Errorf("failed to get current netns: %w", err)
+	}
+	defer origNs.Close()
+	err = netns.Set(ns)
+	if err != nil {
+		Log.Errorf("failed to join sandbox netns: %v", err)
 		return nil
 	}
+	defer func() {
+		if err := netns.Set(origNs); err != nil {
+			Log.Errorf("failed to return to the original netns: %v", err)
+		}
+	}()
+	if tapDevice != "" {
+		err = network.Cleanup(tapDevice)
+		if err != nil {
+			Log.Errorf("failed to delete %s: %v", tapDevice, err)
+		}
+	}
+	if natRule != nil {
+		err = network.DeleteNATRule(*natRule)
+		if err != nil {
+			Log.Errorf("failed to delete NAT rule: %v", err)
+		}
+	}
+	return u.forgetNetwork()
+}
 
-	hooks := map[string][]specs.Hook{
-		"Prestart":        u.Spec.Hooks.Prestart,
-		"CreateRuntime":   u.Spec.Hooks.CreateRuntime,
-		"CreateContainer": u.Spec.Hooks.CreateContainer,
-		"StartContainer":  u.Spec.Hooks.StartContainer,
-		"Poststart":       u.Spec.Hooks.Poststart,
-		"Poststop":        u.Spec.Hooks.Poststop,
-	}[name]
+// forgetNetwork removes the released network resources from the state, so
+// that a later cleanup (e.g. from both the monitor and Kill) is a no-op
+func (u *Unikontainer) forgetNetwork() error {
+	unlock, err := u.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
-	Log.Infof("Found %d %s hooks", len(hooks), name)
+	u.runtime.TapDevice = ""
+	u.runtime.NATRule = nil
+	return u.saveContainerState()
+}
 
-	if len(hooks) == 0 {
-		Log.WithFields(logrus.Fields{
-			"id":    u.State.ID,
-			"name:": name,
-		}).Debug("No hooks")
-		return nil
+// Delete removes the containers base directory and its contents,
+// after executing the Poststop hooks
+func (u *Unikontainer) Delete() error {
+	unlock, err := u.lock()
+	if err != nil {
+		return err
 	}
+	defer unlock()
 
-	s, err := json.Marshal(u.State)
+	// A container that failed before its reexec process was spawned
+	// remains in creating status, but there is nothing running
+	state := u.CurrentState()
+	if state.Status != specs.StateCreating || state.Pid > 0 {
+		err = u.checkTransition("delete", specs.StateStopped)
+		if err != nil {
+			return err
+		}
+	}
+	if len(u.runtime.ExtractedFiles) > 0 {
+		err := cleanupExtractedFiles(u.State.Bundle)
+		if err != nil {
+			return fmt.Errorf("cannot delete bundle %s: %v", u.State.Bundle, err)
+		}
+	}
+	// The network might not have been cleaned up, if the VMM exited
+	// after Kill returned
+	err = u.cleanupNetwork()
 	if err != nil {
 		return err
 	}
-	for _, hook := range hooks {
-		var stdout, stderr bytes.Buffer
-		cmd := exec.Cmd{
-			Path:   hook.Path,
-			Args:   hook.Args,
-			Env:    hook.Env,
-			Stdin:  bytes.NewReader(s),
-			Stdout: &stdout,
-			Stderr: &stderr,
-		}
+	// Release what the VMM left on the host, such as the jail of Firecracker
+	err = u.cleanupVMM()
+	if err != nil {
+		return err
+	}
+	// Poststop hooks run after the container is deleted, but before its
+	// state is gone, unless the monitor has already executed them
+	u.executePoststopHooks()
+	return os.RemoveAll(u.BaseDir)
+}
 
-		if err := cmd.Run(); err != nil {
-			Log.WithFields(logrus.Fields{
-				"id":     u.State.ID,
-				"name:":  name,
-				"error":  err.Error(),
-				"stderr": stderr.String(),
-				"stdout": stdout.String(),
-			}).Error("failed to execute hooks")
-			return fmt.Errorf("failed to execute %s hook '%s': %w", name, cmd.String(), err)
-		}
+// cleanupVMM releases the resources that the VMM left on the host, if any
+func (u *Unikontainer) cleanupVMM() error {
+	vmm, err := hypervisors.NewVMM(hypervisors.VmmType(u.State.Annotations[annotHypervisor]))
+	if err != nil {
+		Log.WithError(err).Warn("failed to get vmm, skipping its cleanup")
+		return nil
+	}
+	if c, ok := vmm.(hypervisors.Cleaner); ok {
+		return c.Cleanup(u.BaseDir)
 	}
 	return nil
 }
 
-// loadUnikontainerState returns a specs.State object containing the info
-// found in stateFilePath
-func loadUnikontainerState(stateFilePath string) (*specs.State, error) {
-	var state specs.State
-	data, err := os.ReadFile(stateFilePath)
+// joinSandboxNetns finds the sandbox id of the container, retrieves the sandbox's init pid,
+// finds the init pid netns and joins it
+func (u Unikontainer) joinSandboxNetNs() error {
+	netnsPath, err := u.sandboxNetnsPath()
+	if err != nil || netnsPath == "" {
+		return err
+	}
+	sandboxInitNetns, err := netns.GetFromPath(netnsPath)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	Log.WithFields(logrus.Fields{
+		"sandboxNetnsPath": netnsPath,
+		"sandboxInitNetns": sandboxInitNetns,
+	}).Info("Joining sandbox's netns")
+	err = netns.Set(sandboxInitNetns)
+	if err != nil {
+		return err
 	}
+	Log.Info("Joined sandbox's netns")
+	return nil
+}
 
-	err = json.Unmarshal(data, &state)
+// sandboxNetnsPath returns the path of the network namespace of the
+// container's sandbox, or an empty string if it does not belong to one
+func (u Unikontainer) sandboxNetnsPath() (string, error) {
+	return sandboxNetnsPath(u.RootDir, u.Spec.Annotations[annotSandboxID])
+}
+
+// sandboxNetnsPath returns the path of the network namespace of the init
+// process of the given sandbox
+func sandboxNetnsPath(rootDir string, sandboxID string) (string, error) {
+	if sandboxID == "" {
+		return "", nil
+	}
+	containerDir := filepath.Join(rootDir, sandboxID)
+	stateFilePath := filepath.Join(containerDir, stateFilename)
+	sandboxInitPid, err := getInitPid(stateFilePath)
 	if err != nil {
-		return nil, err
+		return "", err
+	}
+	return fmt.Sprintf("/proc/%d/ns/net", int(sandboxInitPid)), nil
+}
+
+// Saves current Unikernel state as baseDir/state.json for later use
+func (u *Unikontainer) saveContainerState() error {
+	// Propagate all annotations from spec to state to solve nerdctl hooks errors.
+	// For more info: https://github.com/containerd/nerdctl/issues/133
+	for key, value := range u.Spec.Annotations {
+		if _, ok := u.State.Annotations[key]; !ok {
+			u.State.Annotations[key] = value
+		}
 	}
-	return &state, nil
+
+	data, err := json.Marshal(containerState{
+		Version: stateVersion,
+		OCI:     u.State,
+		Runtime: u.runtime,
+	})
+	if err != nil {
+		return err
+	}
+
+	stateName := filepath.Join(u.BaseDir, stateFilename)
+	return writeFileAtomic(stateName, data, 0o644)
 }
 
 func (u *Unikontainer) GetInitSockAddr() string {
@@ -599,8 +899,8 @@ func (u *Unikontainer) GetUruncSockAddr() string {
 }
 
 // ListeAndAwaitMsg opens a new connection to UruncSock and
-// waits for the expectedMsg message
-func (u *Unikontainer) ListenAndAwaitMsg(sockAddr string, msg IPCMessage) error {
+// waits for the expectedMsg message, for as long as opts allow
+func (u *Unikontainer) ListenAndAwaitMsg(sockAddr string, msg IPCMessageType, opts AwaitOptions) error {
 	listener, err := CreateListener(sockAddr, true)
 	if err != nil {
 		return err
@@ -617,38 +917,112 @@ func (u *Unikontainer) ListenAndAwaitMsg(sockAddr string, msg IPCMessage) error
 			logrus.WithError(err).Errorf("failed to unlink %s", sockAddr)
 		}
 	}()
-	return AwaitMessage(listener, msg)
+	_, err = AwaitMessage(listener, msg, opts)
+	return err
 }
 
-// SendReexecStarted sends an ReexecStarted message to InitSock
+// SendReexecStarted sends an ReexecStarted message to InitSock, along
+// with the PID of the reexec process
 func (u *Unikontainer) SendReexecStarted() error {
 	sockAddr := getInitSockAddr(u.BaseDir)
-	return sendIPCMessageWithRetry(sockAddr, ReexecStarted, true)
+	msg := newIPCMessage(ReexecStarted, u.State.ID, nil)
+	msg.Pid = SelfPid()
+	return sendIPCMessageWithRetry(sockAddr, msg, true)
+}
+
+// SendReexecFailed reports to the parent process, over InitSock, an error
+// that prevented the reexec process from starting, before the Unikontainer
+// could be loaded
+func SendReexecFailed(rootDir string, containerID string, reexecErr error) error {
+	sockAddr := getInitSockAddr(filepath.Join(rootDir, containerID))
+	return sendIPCMessageWithRetry(sockAddr, newIPCMessage(ReexecStarted, containerID, reexecErr), true)
 }
 
 // SendAckReexec sends an AckReexec message to UruncSock
 func (u *Unikontainer) SendAckReexec() error {
 	sockAddr := getUruncSockAddr(u.BaseDir)
-	return sendIPCMessageWithRetry(sockAddr, AckReexec, true)
+	return sendIPCMessageWithRetry(sockAddr, newIPCMessage(AckReexec, u.State.ID, nil), true)
+}
+
+// Start makes sure that the container has been created and
+// notifies the reexec process to execve the VMM. The reexec process
+// must execve the VMM within timeout.
+func (u *Unikontainer) Start(timeout time.Duration) error {
+	err := u.checkTransition("start", specs.StateCreated)
+	if err != nil {
+		return err
+	}
+	return u.SendStartExecve(timeout)
 }
 
-// SendStartExecve sends an StartExecve message to UruncSock
-func (u *Unikontainer) SendStartExecve() error {
+// SendStartExecve sends an StartExecve message to UruncSock and waits
+// until the reexec process executes the VMM. Any error of the reexec
+// process until then is returned.
+func (u *Unikontainer) SendStartExecve(timeout time.Duration) error {
 	sockAddr := getUruncSockAddr(u.BaseDir)
-	return sendIPCMessageWithRetry(sockAddr, StartExecve, true)
+	return sendIPCRequestWithRetry(sockAddr, newIPCMessage(StartExecve, u.State.ID, nil), true, timeout)
 }
 
-// isRunning returns true if the PID is alive or hedge.ListVMs returns our containerID
+// isRunning returns true if the PID is alive (and it is not a zombie or
+// a reused PID) or hedge.ListVMs returns our containerID
 func (u *Unikontainer) isRunning() bool {
-	vmmType := hypervisors.VmmType(u.State.Annotations[annotType])
+	vmmType := hypervisors.VmmType(u.State.Annotations[annotHypervisor])
 	if vmmType != hypervisors.HedgeVmm {
-		return syscall.Kill(u.State.Pid, syscall.Signal(0)) == nil
+		if u.State.Pid <= 0 {
+			return false
+		}
+		return processAlive(u.State.Pid, u.runtime.StartTime)
 	}
 	hedge := hypervisors.Hedge{}
 	state := hedge.VMState(u.State.ID)
 	return state == "running"
 }
 
+// monitored returns true if the VMM is waited by a monitor process
+func (u *Unikontainer) monitored() bool {
+	return u.runtime.MonitorPid > 0 && processAlive(u.runtime.MonitorPid, "")
+}
+
+// CurrentState returns the OCI state of the unikernel container. The status
+// stored in state.json is refreshed based on whether the VMM (or the reexec
+// process, before start) is still alive, and the stopped status is persisted.
+func (u *Unikontainer) CurrentState() *specs.State {
+	if u.exited() {
+		err := u.markStopped(exitReasonExited)
+		if err != nil {
+			Log.WithError(err).Warn("failed to save the stopped state")
+			u.State.Status = specs.StateStopped
+		}
+	}
+	state := *u.State
+	return &state
+}
+
+// exited returns true if the VMM (or the reexec process, before start) of a
+// container that is not yet marked as stopped is gone. It does not change the
+// state of the container.
+func (u *Unikontainer) exited() bool {
+	switch u.State.Status {
+	case specs.StateCreated, specs.StateRunning, statePaused:
+		return !u.isRunning()
+	case specs.StateCreating:
+		// The reexec process has not notified us yet. If it was never
+		// spawned there is nothing to check.
+		return u.State.Pid > 0 && !u.isRunning()
+	}
+	return false
+}
+
+// Hypervisor returns the type of the VMM that runs the unikernel
+func (u *Unikontainer) Hypervisor() string {
+	return u.State.Annotations[annotHypervisor]
+}
+
+// UnikernelType returns the type of the unikernel (e.g. rumprun, unikraft)
+func (u *Unikontainer) UnikernelType() string {
+	return u.State.Annotations[annotType]
+}
+
 // getNetworkType checks if current container is a knative user-container
 func (u Unikontainer) getNetworkType() string {
 	if u.Spec.Annotations["io.kubernetes.cri.container-name"] == "user-container" {
@@ -0,0 +1,203 @@
+// Copyright (c) 2023-2024, Nubificus LTD
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package unikontainers
+
+import (
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/nubificus/urunc/pkg/unikontainers/hypervisors"
+	"github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+)
+
+func TestWritePidFileSurvivesExecve(t *testing.T) {
+	tmpDir := t.TempDir()
+	pidFilePath := filepath.Join(tmpDir, "container.pid")
+
+	// The shell plays the role of the reexec process, which
+	// execve's the VMM (sleep) without changing its PID
+	cmd := exec.Command("sh", "-c", "read _; exec sleep 10")
+	stdin, err := cmd.StdinPipe()
+	assert.NoError(t, err)
+	err = cmd.Start()
+	assert.NoError(t, err)
+	defer func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	}()
+
+	u := &Unikontainer{
+		State: &specs.State{
+			ID:  "test",
+			Pid: cmd.Process.Pid,
+		},
+	}
+	err = u.WritePidFile(pidFilePath)
+	assert.NoError(t, err, "Expected no error in writing PID file")
+
+	// Let the process execve
+	_, err = stdin.Write([]byte("\n"))
+	assert.NoError(t, err)
+	commPath := filepath.Join("/proc", strconv.Itoa(cmd.Process.Pid), "comm")
+	execved := false
+	for i := 0; i < 100; i++ {
+		comm, err := os.ReadFile(commPath)
+		if err == nil && strings.TrimSpace(string(comm)) == "sleep" {
+			execved = true
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.True(t, execved, "Expected process to execve sleep")
+
+	content, err := os.ReadFile(pidFilePath)
+	assert.NoError(t, err, "Expected no error in reading PID file")
+	pid, err := strconv.Atoi(string(content))
+	assert.NoError(t, err, "Expected PID file to contain a number")
+	assert.Equal(t, cmd.Process.Pid, pid, "Expected PID file to contain the PID of the VMM")
+	_, err = os.Stat(filepath.Join(tmpDir, ".container.pid"))
+	assert.True(t, os.IsNotExist(err), "Expected temporary PID file to be renamed")
+}
+
+func TestWritePidFileMonitor(t *testing.T) {
+	pidFilePath := filepath.Join(t.TempDir(), "container.pid")
+	u := &Unikontainer{
+		State:   &specs.State{ID: "test", Pid: 100},
+		runtime: runtimeState{MonitorPid: 99},
+	}
+	err := u.WritePidFile(pidFilePath)
+	assert.NoError(t, err)
+	content, err := os.ReadFile(pidFilePath)
+	assert.NoError(t, err)
+	assert.Equal(t, "100", string(content), "Expected PID file to contain the PID of the VMM")
+}
+
+// startTestVMM starts a process that stands in for the VMM of a container and
+// returns a channel that is closed once it exits
+func startTestVMM(t *testing.T, cloneFlags uintptr) (*exec.Cmd, <-chan struct{}) {
+	t.Helper()
+	cmd := exec.Command("sleep", "60")
+	cmd.SysProcAttr = &syscall.SysProcAttr{Cloneflags: cloneFlags}
+	assert.NoError(t, cmd.Start())
+	done := make(chan struct{})
+	go func() {
+		_ = cmd.Wait()
+		close(done)
+	}()
+	t.Cleanup(func() {
+		_ = cmd.Process.Kill()
+		<-done
+	})
+	return cmd, done
+}
+
+// fakeVMMBinary puts an executable with the name of the given VMM in the PATH
+func fakeVMMBinary(t *testing.T, name string) {
+	t.Helper()
+	binDir := t.TempDir()
+	err := os.WriteFile(filepath.Join(binDir, name), []byte("#!/bin/sh\n"), 0o755) //nolint: gosec
+	assert.NoError(t, err)
+	t.Setenv("PATH", binDir+":"+os.Getenv("PATH"))
+}
+
+func TestKillFirecrackerWithoutAPI(t *testing.T) {
+	fakeVMMBinary(t, hypervisors.FirecrackerBinary)
+
+	tests := []struct {
+		name   string
+		socket bool
+	}{
+		{name: "no api socket"},
+		// Firecracker has not served the socket yet, or it was left behind
+		{name: "api socket not served", socket: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			cmd, done := startTestVMM(t, 0)
+			u := newTestUnikontainer(t, specs.StateRunning, cmd.Process.Pid)
+			u.State.Annotations[annotHypervisor] = string(hypervisors.FirecrackerVmm)
+			assert.NoError(t, u.saveContainerState())
+			if tc.socket {
+				listener, err := net.Listen("unix", filepath.Join(u.BaseDir, hypervisors.FCSockFilename))
+				assert.NoError(t, err)
+				listener.(*net.UnixListener).SetUnlinkOnClose(false)
+				assert.NoError(t, listener.Close())
+			}
+
+			err := u.Kill(unix.SIGKILL, false)
+			assert.NoError(t, err)
+			select {
+			case <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("Expected the VMM to be killed")
+			}
+		})
+	}
+}
+
+func TestKillVMMInPidNamespace(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("PID namespaces require root")
+	}
+	fakeVMMBinary(t, hypervisors.FirecrackerBinary)
+
+	// The VMM is the init process of its PID namespace, like a jailed
+	// Firecracker, so it ignores SIGTERM
+	cmd, done := startTestVMM(t, unix.CLONE_NEWPID)
+	u := newTestUnikontainer(t, specs.StateRunning, cmd.Process.Pid)
+	u.State.Annotations[annotHypervisor] = string(hypervisors.FirecrackerVmm)
+	u.runtime.PidNamespace = true
+	assert.NoError(t, u.saveContainerState())
+
+	err := u.Kill(unix.SIGTERM, false)
+	assert.NoError(t, err)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected SIGTERM to stop the VMM")
+	}
+}
+
+func TestKillWaitsForMonitor(t *testing.T) {
+	vmm, vmmDone := startTestVMM(t, 0)
+	monitor, _ := startTestVMM(t, 0)
+	u := newTestUnikontainer(t, specs.StateRunning, vmm.Process.Pid)
+	u.runtime.MonitorPid = monitor.Process.Pid
+	assert.NoError(t, u.saveContainerState())
+
+	// The monitor records the exit of the VMM and exits
+	go func() {
+		<-vmmDone
+		m := &Unikontainer{BaseDir: u.BaseDir, Spec: &specs.Spec{}, State: &specs.State{ID: "test"}}
+		assert.NoError(t, m.Reap(137, "killed by signal SIGKILL"))
+		_ = monitor.Process.Kill()
+	}()
+
+	err := u.Kill(unix.SIGKILL, false)
+	assert.NoError(t, err)
+	saved, err := loadUnikontainerState(filepath.Join(u.BaseDir, stateFilename))
+	assert.NoError(t, err)
+	assert.Equal(t, specs.StateStopped, saved.OCI.Status)
+	assert.NotNil(t, saved.Runtime.ExitedAt, "Expected the monitor to record the exit before Kill returns")
+}
@@ -22,17 +22,22 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/nubificus/urunc/internal/constants"
 	"github.com/opencontainers/runtime-spec/specs-go"
+	"golang.org/x/sys/unix"
 )
 
 const (
 	configFilename    = "config.json"
 	stateFilename     = "state.json"
+	lockFilename      = "state.lock"
 	initPidFilename   = "init.pid"
 	uruncJSONFilename = "urunc.json"
 	rootfsDirName     = "rootfs"
+	killWaitTimeout   = 2 * time.Second
+	exitPollInterval  = 10 * time.Millisecond
 )
 
 // getInitPid extracts "init_process_pid" value from the given JSON file
@@ -137,6 +142,31 @@ func writePidFile(path string, pid int) error {
 	return os.Rename(tmpName, path)
 }
 
+// writeFileAtomic writes data to a temporary file in the same directory and
+// renames it to path, so that readers never see a partially written file
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-")
+	if err != nil {
+		return err
+	}
+	tmpName := f.Name()
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmpName, perm)
+	}
+	if err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+	return os.Rename(tmpName, path)
+}
+
 // handleQueueProxy adds a hardcoded IP to the process's environment.
 // Then, the container is identified as a non-bima container
 // is spawned using runc.
@@ -180,3 +210,153 @@ func remove(s []string, i int) []string {
 	s[i] = s[len(s)-1]
 	return s[:len(s)-1]
 }
+
+// waitForExit polls the given process until it exits or the timeout expires.
+// It returns true if the process has exited.
+func waitForExit(pid int, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		if !processAlive(pid, "") {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(exitPollInterval)
+	}
+}
+
+// getDescendants returns the PIDs of all the processes that were
+// spawned, directly or indirectly, by the given process
+func getDescendants(pid int) []int {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil
+	}
+	children := make(map[int][]int)
+	for _, entry := range entries {
+		p, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		ppid, err := getParentPid(p)
+		if err != nil {
+			continue
+		}
+		children[ppid] = append(children[ppid], p)
+	}
+
+	var descendants []int
+	queue := children[pid]
+	for len(queue) > 0 {
+		p := queue[0]
+		queue = queue[1:]
+		descendants = append(descendants, p)
+		queue = append(queue, children[p]...)
+	}
+	return descendants
+}
+
+// getParentPid reads the parent PID of a process from /proc/<pid>/stat
+func getParentPid(pid int) (int, error) {
+	fields, err := readProcStat(pid)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(fields[1])
+}
+
+// readProcStat returns the fields of /proc/<pid>/stat that follow the comm
+// field, starting with the state of the process
+func readProcStat(pid int) ([]string, error) {
+	data, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return nil, err
+	}
+	// The comm field might contain spaces, so skip everything up to
+	// the last parenthesis. The fields after it are: state ppid ...
+	stat := string(data)
+	end := strings.LastIndexByte(stat, ')')
+	if end < 0 {
+		return nil, fmt.Errorf("invalid stat format for process %d", pid)
+	}
+	fields := strings.Fields(stat[end+1:])
+	// starttime is the 22nd field of the stat file
+	if len(fields) < 20 {
+		return nil, fmt.Errorf("invalid stat format for process %d", pid)
+	}
+	return fields, nil
+}
+
+// getProcessStartTime returns the start time of the given process, in clock
+// ticks after system boot. Together with the PID, it uniquely identifies a process.
+func getProcessStartTime(pid int) (string, error) {
+	fields, err := readProcStat(pid)
+	if err != nil {
+		return "", err
+	}
+	return fields[19], nil
+}
+
+// processAlive returns true if the given process exists and it is not a zombie.
+// If startTime is not empty, the process must also have the same start time,
+// otherwise the PID has been reused by another process.
+func processAlive(pid int, startTime string) bool {
+	fields, err := readProcStat(pid)
+	if err != nil {
+		return false
+	}
+	if fields[0] == "Z" || fields[0] == "X" {
+		return false
+	}
+	return startTime == "" || fields[19] == startTime
+}
+
+// netnsInode returns the inode of the network namespace at the given path
+func netnsInode(path string) (uint64, error) {
+	var st unix.Stat_t
+	err := unix.Stat(path, &st)
+	if err != nil {
+		return 0, err
+	}
+	return st.Ino, nil
+}
+
+// boolOption returns the value of a boolean option, which is set per container
+// through the given annotation or for the whole host through the environment
+// variable of urunc. The annotation takes precedence. It is false if unset.
+func boolOption(spec *specs.Spec, annotation string, env string) (bool, error) {
+	value, ok := spec.Annotations[annotation]
+	if !ok {
+		return parseBoolOption(os.Getenv(env), env)
+	}
+	return parseBoolOption(value, annotation)
+}
+
+// parseBoolOption parses the value of the given boolean option, which is
+// false if empty
+func parseBoolOption(value string, name string) (bool, error) {
+	if value == "" {
+		return false, nil
+	}
+	enabled, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid value %q for %s: %w", value, name, err)
+	}
+	return enabled, nil
+}
+
+// SelfPid returns the PID of the calling process as the host sees it, which
+// differs from os.Getpid in a new PID namespace, such as the one of the reexec
+// process of a jailed Firecracker. /proc is the one of the host, since the
+// mount namespace is shared.
+func SelfPid() int {
+	link, err := os.Readlink("/proc/self")
+	if err == nil {
+		pid, err := strconv.Atoi(link)
+		if err == nil {
+			return pid
+		}
+	}
+	return os.Getpid()
+}
@@ -18,9 +18,12 @@ import (
 	"encoding/json"
 	"io/ioutil"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"strconv"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/opencontainers/runtime-spec/specs-go"
 	"github.com/stretchr/testify/assert"
@@ -365,3 +368,112 @@ func TestLoadSpec(t *testing.T) {
 		assert.Contains(t, err.Error(), "failed to parse specification json", "Expected specific error message")
 	})
 }
+
+func TestWaitForExit(t *testing.T) {
+	t.Run("wait for exit process exited", func(t *testing.T) {
+		t.Parallel()
+		cmd := exec.Command("sleep", "0.1")
+		err := cmd.Start()
+		assert.NoError(t, err)
+		go func() {
+			_ = cmd.Wait()
+		}()
+
+		assert.True(t, waitForExit(cmd.Process.Pid, 2*time.Second), "Expected process to exit")
+	})
+
+	t.Run("wait for exit timeout", func(t *testing.T) {
+		t.Parallel()
+		cmd := exec.Command("sleep", "10")
+		err := cmd.Start()
+		assert.NoError(t, err)
+		defer func() {
+			_ = cmd.Process.Kill()
+			_ = cmd.Wait()
+		}()
+
+		assert.False(t, waitForExit(cmd.Process.Pid, 50*time.Millisecond), "Expected process to be still running")
+	})
+}
+
+func TestGetDescendants(t *testing.T) {
+	// Spawn a shell which spawns a child of its own
+	cmd := exec.Command("sh", "-c", "sleep 10 & wait")
+	err := cmd.Start()
+	assert.NoError(t, err)
+	defer func() {
+		for _, pid := range getDescendants(cmd.Process.Pid) {
+			_ = syscall.Kill(pid, syscall.SIGKILL)
+		}
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	}()
+
+	var descendants []int
+	for i := 0; i < 100; i++ {
+		descendants = getDescendants(cmd.Process.Pid)
+		if len(descendants) > 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Len(t, descendants, 1, "Expected exactly one descendant")
+
+	ppid, err := getParentPid(descendants[0])
+	assert.NoError(t, err, "Expected no error in getting parent PID")
+	assert.Equal(t, cmd.Process.Pid, ppid, "Expected the shell to be the parent")
+}
+
+func TestProcessAlive(t *testing.T) {
+	t.Run("process alive running process", func(t *testing.T) {
+		t.Parallel()
+		pid := os.Getpid()
+		startTime, err := getProcessStartTime(pid)
+		assert.NoError(t, err)
+		assert.True(t, processAlive(pid, ""), "Expected process to be alive")
+		assert.True(t, processAlive(pid, startTime), "Expected process to be alive")
+	})
+
+	t.Run("process alive reused pid", func(t *testing.T) {
+		t.Parallel()
+		assert.False(t, processAlive(os.Getpid(), "0"), "Expected start time mismatch to be detected")
+	})
+
+	t.Run("process alive zombie", func(t *testing.T) {
+		t.Parallel()
+		cmd := exec.Command("true")
+		err := cmd.Start()
+		assert.NoError(t, err)
+		defer func() {
+			_ = cmd.Wait()
+		}()
+
+		// The process remains a zombie until we reap it
+		assert.True(t, waitForExit(cmd.Process.Pid, 2*time.Second), "Expected zombie process to be considered exited")
+		assert.False(t, processAlive(cmd.Process.Pid, ""), "Expected zombie process not to be alive")
+	})
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "state.json")
+	err := os.WriteFile(path, []byte("old content"), 0600)
+	assert.NoError(t, err)
+
+	err = writeFileAtomic(path, []byte("new"), 0o644)
+	assert.NoError(t, err)
+	content, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "new", string(content))
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o644), info.Mode().Perm())
+
+	entries, err := os.ReadDir(tmpDir)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1, "Expected no temporary files to be left behind")
+}
+
+func TestSelfPid(t *testing.T) {
+	assert.Equal(t, os.Getpid(), SelfPid(), "Expected the PID of the host outside of a new PID namespace")
+}